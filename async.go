@@ -0,0 +1,189 @@
+package ctxlog
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// async writes encoded lines in a background goroutine, see Async.
+type async struct {
+	// mu is read locked by senders, Close locks it to close ch safely.
+	mu      sync.RWMutex
+	closed  bool
+	drop    bool
+	dropped atomic.Uint64
+	ch      chan asyncLine
+	done    chan struct{}
+	pool    *sync.Pool // pool of line buffers
+
+	// reporting is set while OnError callback runs in the background goroutine with id gid,
+	// lines printed by the callback are written synchronously, as the goroutine can't receive them.
+	reporting atomic.Bool
+	gid       int64
+}
+
+// asyncLine is an encoded message queued for writing.
+// If flushed is set, it is a marker which is closed when all lines queued before it are written.
+// If group is set, it is lines of a Batch, which are written together.
+type asyncLine struct {
+	buf     *bytes.Buffer
+	spans   [][2]int
+	level   string
+	flushed chan struct{}
+	group   []asyncLine
+}
+
+func newAsync(l *Log, size int, drop bool) *async {
+	a := &async{
+		drop: drop,
+		ch:   make(chan asyncLine, size),
+		done: make(chan struct{}),
+		pool: l.bufPool,
+	}
+	go a.run(l)
+	return a
+}
+
+// maxAsyncBatch is the maximum number of queued lines written with a single Write call.
+const maxAsyncBatch = 64
+
+// run writes queued lines until ch is closed.
+// Lines queued at the same time are written in batches to amortize syscalls.
+func (a *async) run(l *Log) {
+	defer close(a.done)
+	a.gid, _ = goid()
+
+	batch := make([]asyncLine, 0, maxAsyncBatch)
+	scratch := new(bytes.Buffer)
+	for line := range a.ch {
+		batch = line.appendTo(batch)
+	drain:
+		for len(batch) < maxAsyncBatch && line.flushed == nil {
+			select {
+			case line = <-a.ch:
+				if line.buf == nil && line.flushed == nil && line.group == nil {
+					break drain // ch is closed.
+				}
+				batch = line.appendTo(batch)
+			default:
+				break drain
+			}
+		}
+
+		if err := l.writeBatch(batch, scratch); err != nil {
+			a.reporting.Store(true)
+			l.writeError(err)
+			a.reporting.Store(false)
+		}
+		for i, line := range batch {
+			if line.flushed != nil {
+				close(line.flushed)
+			} else {
+				line.buf.Reset()
+				a.pool.Put(line.buf)
+			}
+			batch[i] = asyncLine{}
+		}
+		batch = batch[:0]
+	}
+}
+
+// appendTo appends line to batch, lines of a group are appended one by one.
+func (line asyncLine) appendTo(batch []asyncLine) []asyncLine {
+	if line.group != nil {
+		return append(batch, line.group...)
+	}
+	return append(batch, line)
+}
+
+// send queues buf for writing and reports whether buf was taken.
+// It returns false if a is closed, then the caller should write buf itself.
+func (a *async) send(buf *bytes.Buffer, spans [][2]int, level string) bool {
+	if a.reentrant() {
+		return false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return false
+	}
+
+	a.queue(asyncLine{buf: buf, spans: append([][2]int(nil), spans...), level: level})
+	return true
+}
+
+// sendGroup queues lines of a Batch to be written together and reports whether they were taken,
+// see send.
+func (a *async) sendGroup(lines []asyncLine) bool {
+	if a.reentrant() {
+		return false
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return false
+	}
+
+	a.queue(asyncLine{group: lines})
+	return true
+}
+
+// queue sends line to ch, unless it is full and AsyncDrop is set, then line is dropped.
+// Must be called with mu read locked.
+func (a *async) queue(line asyncLine) {
+	if !a.drop {
+		a.ch <- line
+		return
+	}
+	select {
+	case a.ch <- line:
+	default:
+		for _, l := range line.appendTo(nil) {
+			a.dropped.Add(1)
+			l.buf.Reset()
+			a.pool.Put(l.buf)
+		}
+	}
+}
+
+// reentrant reports whether it is called by OnError callback in the background goroutine.
+func (a *async) reentrant() bool {
+	if !a.reporting.Load() {
+		return false
+	}
+	id, ok := goid()
+	return ok && id == a.gid
+}
+
+// flush waits until all lines queued so far are written.
+// Called by OnError callback, it returns immediately, lines queued after the failed ones are written later.
+func (a *async) flush() {
+	if a.reentrant() {
+		return
+	}
+	a.mu.RLock()
+	if a.closed {
+		a.mu.RUnlock()
+		<-a.done
+		return
+	}
+	flushed := make(chan struct{})
+	a.ch <- asyncLine{flushed: flushed}
+	a.mu.RUnlock()
+	<-flushed
+}
+
+// close writes queued lines and stops the background goroutine.
+// Called by OnError callback, it doesn't wait for the goroutine to stop.
+func (a *async) close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.ch)
+	}
+	a.mu.Unlock()
+	if !a.reentrant() {
+		<-a.done
+	}
+}
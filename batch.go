@@ -0,0 +1,84 @@
+package ctxlog
+
+import (
+	"bytes"
+	"context"
+	"sync"
+)
+
+// Batch collects messages and writes them together on Commit,
+// so messages printed by other goroutines don't get between them.
+// It is safe for concurrent use.
+type Batch struct {
+	l   *Log
+	ctx context.Context
+
+	mu        sync.Mutex
+	committed bool
+	lines     []asyncLine
+}
+
+// Batch returns Batch which prints messages with l and fields stored in ctx.
+func (l *Log) Batch(ctx context.Context) *Batch {
+	b := &Batch{ctx: ctx}
+	if l == nil {
+		return b
+	}
+
+	n := *l
+	n.batch = b
+	b.l = &n
+	return b
+}
+
+// Print adds message msg with specified fields to the batch.
+// Messages printed after Commit are written immediately.
+func (b *Batch) Print(msg string, fields ...Field) {
+	b.l.output(b.ctx, 2, msg, fields)
+}
+
+// add takes buf with encoded message at level and reports whether it was added to the batch.
+// It returns false if the batch was committed, then the caller should write buf itself.
+func (b *Batch) add(buf *bytes.Buffer, spans [][2]int, level string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.committed {
+		return false
+	}
+
+	b.lines = append(b.lines, asyncLine{buf: buf, spans: append([][2]int(nil), spans...), level: level})
+	return true
+}
+
+// Commit writes collected messages under a single lock, with one Write call per writer.
+// If some writes fail, the rest are still written and errors are returned joined.
+// With Async, the messages are queued together and written in the background, errors are passed to OnError.
+// Only the first call writes anything, later calls return nil.
+func (b *Batch) Commit() error {
+	if b.l == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.committed {
+		return nil
+	}
+	b.committed = true
+
+	if b.l.async != nil && len(b.lines) > 0 && b.l.async.sendGroup(b.lines) {
+		b.lines = nil
+		return nil
+	}
+
+	scratch := b.l.bufPool.Get().(*bytes.Buffer)
+	err := b.l.writeError(b.l.writeBatch(b.lines, scratch))
+	scratch.Reset()
+	b.l.bufPool.Put(scratch)
+	for _, line := range b.lines {
+		line.buf.Reset()
+		b.l.bufPool.Put(line.buf)
+	}
+	b.lines = nil
+	return err
+}
@@ -0,0 +1,99 @@
+package ctxlog_test
+
+import (
+	"context"
+	"io"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/kaey/ctxlog"
+)
+
+// devNull returns file which discards writes, unlike io.Discard every Write is a syscall.
+func devNull(b *testing.B) *os.File {
+	f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { f.Close() })
+	return f
+}
+
+func BenchmarkPrintParallel(b *testing.B) {
+	log := ctxlog.New(devNull(b))
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			log.Print(ctx, "hello", ctxlog.Str("foo", "bar"), ctxlog.Int("n", 1))
+		}
+	})
+}
+
+func BenchmarkPrintParallelAsync(b *testing.B) {
+	log := ctxlog.New(devNull(b), ctxlog.Async(1024))
+	defer log.Close()
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			log.Print(ctx, "hello", ctxlog.Str("foo", "bar"), ctxlog.Int("n", 1))
+		}
+	})
+	log.Sync()
+}
+
+func BenchmarkPrint(b *testing.B) {
+	log := ctxlog.New(io.Discard)
+	ctx := ctxlog.With(context.Background(), ctxlog.Str("request_id", "abc"))
+
+	b.Run("0", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			log.Print(ctx, "hello")
+		}
+	})
+	b.Run("3", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			log.Print(ctx, "hello", ctxlog.Str("foo", "bar"), ctxlog.Int("n", i), ctxlog.Bool("ok", true))
+		}
+	})
+	b.Run("10", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			log.Print(ctx, "hello",
+				ctxlog.Str("a", "foo"), ctxlog.Str("b", "bar"), ctxlog.Int("c", i), ctxlog.Int64("d", 1),
+				ctxlog.Float64("e", 1.5), ctxlog.Bool("f", true), ctxlog.Dur("g", time.Second),
+				ctxlog.Str("h", "baz"), ctxlog.Int("i", 2), ctxlog.Level(ctxlog.LevelWarn))
+		}
+	})
+}
+
+func BenchmarkPrintLevelMetrics(b *testing.B) {
+	var n int
+	log := ctxlog.New(io.Discard, ctxlog.LevelMetrics(func(level string) { n++ }))
+	ctx := context.Background()
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		log.Print(ctx, "hello", ctxlog.Str("foo", "bar"))
+	}
+}
+
+func BenchmarkPrintErrorStack(b *testing.B) {
+	log := ctxlog.New(io.Discard)
+	ctx := context.Background()
+	pc := make([]uintptr, 32)
+	pc = pc[:runtime.Callers(1, pc)]
+	err := &stackError{msg: "failed", pc: pc}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		log.Print(ctx, "hello", ctxlog.Error(err))
+	}
+}
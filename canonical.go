@@ -0,0 +1,87 @@
+package ctxlog
+
+import (
+	"context"
+	"sync"
+)
+
+type summarykeytype struct{}
+
+var summarykey = summarykeytype{}
+
+// summary is a set of fields shared by all contexts derived from the one returned by WithSummary.
+type summary struct {
+	mu     sync.Mutex
+	fields []Field
+}
+
+// WithSummary returns new context with an empty set of fields, which are set by SetField and printed by End.
+func WithSummary(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, summarykey, new(summary))
+}
+
+// SetField sets field f of the summary in ctx, replacing field with the same key, see WithSummary.
+// Unlike With, it modifies the context in place, so functions deep in the call stack
+// can add fields to the line printed by End, like the final status of the request.
+// It does nothing if WithSummary wasn't called for ctx. It is safe for concurrent use.
+func SetField(ctx context.Context, f Field) {
+	if ctx == nil {
+		return
+	}
+	s, ok := ctx.Value(summarykey).(*summary)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range s.fields {
+		if s.fields[i].key == f.key {
+			s.fields[i] = f
+			return
+		}
+	}
+	s.fields = append(s.fields, f)
+}
+
+// End prints a summary message of a request with Global logger, see Log.End.
+func End(ctx context.Context, msg string, fields ...Field) {
+	log.Load().end(ctx, msg, fields)
+}
+
+// End prints a summary message of a request with all fields stored in ctx, fields set by SetField
+// and "elapsed_ms" field if StartTimer was called for ctx. Use it to print a single canonical line per request
+// instead of many small ones:
+//
+//	ctx = ctxlog.WithSummary(ctxlog.StartTimer(ctx))
+//	defer log.End(ctx, "request")
+//	...
+//	ctxlog.SetField(ctx, ctxlog.Int("status", 200))
+//
+// Values implementing LogValuer are resolved when the line is printed, so they can report the final state
+// of the request. Lazy fields are evaluated at most once though: if one was printed by an earlier message,
+// End prints the same value, use SetField for the final state instead.
+func (l *Log) End(ctx context.Context, msg string, fields ...Field) {
+	l.end(ctx, msg, fields)
+}
+
+func (l *Log) end(ctx context.Context, msg string, fields []Field) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	fs := make([]Field, len(fields), len(fields)+1)
+	copy(fs, fields)
+	if s, ok := ctx.Value(summarykey).(*summary); ok {
+		s.mu.Lock()
+		fs = append(fs, s.fields...)
+		s.mu.Unlock()
+	}
+	if f := Elapsed(ctx); f.key != "" {
+		fs = append(fs, f)
+	}
+	l.output(ctx, 3, msg, fs)
+}
@@ -0,0 +1,48 @@
+package ctxlog_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/kaey/ctxlog"
+)
+
+func TestEnd(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.NoTime())
+	ctx := ctxlog.StartTimer(context.Background())
+	status := 0
+	ctx = ctxlog.With(ctx, ctxlog.Str("path", "/"), ctxlog.Lazy("status", func() any { return status }))
+
+	func() {
+		defer log.End(ctx, "request")
+		status = 200
+	}()
+	log.End(context.Background(), "no timer")
+
+	expected := `{"msg":"request","path":"/","status":200,"elapsed_ms":0}` + "\n" + `{"msg":"no timer"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestSetField(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.NoTime())
+	ctx := ctxlog.WithSummary(context.Background())
+
+	ctxlog.SetField(ctx, ctxlog.Int("status", 500))
+	child := ctxlog.With(ctx, ctxlog.Str("path", "/"))
+	ctxlog.SetField(child, ctxlog.Int("status", 200))
+	ctxlog.SetField(child, ctxlog.Str("user", "a"))
+	ctxlog.SetField(context.Background(), ctxlog.Str("lost", "a"))
+	log.End(ctx, "request")
+
+	expected := `{"msg":"request","status":200,"user":"a"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
@@ -0,0 +1,116 @@
+package ctxlog
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorPurple = "\x1b[35m"
+	colorCyan   = "\x1b[36m"
+	colorGray   = "\x1b[90m"
+)
+
+const consoleTimeFormat = "2006-01-02 15:04:05.000"
+
+// appendConsole appends e to b as a human readable line: time level msg key=val...
+// If color is set, level, msg and keys are highlighted with ANSI escape sequences.
+func (l *Log) appendConsole(b []byte, e *entry, color bool) ([]byte, error) {
+	if e.hasTime {
+		b = colorStart(b, color, colorGray)
+		if l.timeFormat == "" {
+			b = e.time.AppendFormat(b, consoleTimeFormat)
+		} else {
+			b = l.appendLogfmtTime(b, e.time)
+		}
+		b = colorEnd(b, color)
+		b = append(b, ' ')
+	}
+
+	if e.hasLevel {
+		b = colorStart(b, color, levelColor(e.level))
+		b = append(b, strings.ToUpper(e.level)...)
+		b = colorEnd(b, color)
+		for n := len(e.level); n < 5; n++ {
+			b = append(b, ' ')
+		}
+		b = append(b, ' ')
+	}
+
+	b = colorStart(b, color, colorBold)
+	b = appendConsoleMsg(b, e.msg)
+	b = colorEnd(b, color)
+
+	b, err := appendLogfmtFields(b, "", e.fields, color)
+	if err != nil {
+		return b, err
+	}
+
+	b = append(b, '\n')
+	return b, nil
+}
+
+// appendConsoleMsg appends msg as it is, unless it contains characters which are not printable,
+// like newlines or escape sequences, then it is quoted, so it can't split the line or reach the terminal.
+func appendConsoleMsg(b []byte, msg string) []byte {
+	for _, r := range msg {
+		if r == utf8.RuneError || !unicode.IsPrint(r) {
+			return strconv.AppendQuote(b, msg)
+		}
+	}
+	return append(b, msg...)
+}
+
+func colorStart(b []byte, color bool, code string) []byte {
+	if !color {
+		return b
+	}
+	return append(b, code...)
+}
+
+func colorEnd(b []byte, color bool) []byte {
+	if !color {
+		return b
+	}
+	return append(b, colorReset...)
+}
+
+func levelColor(level string) string {
+	switch level {
+	case LevelDebug:
+		return colorGray
+	case LevelWarn:
+		return colorYellow
+	case LevelError:
+		return colorRed
+	case LevelFatal:
+		return colorPurple
+	default:
+		return colorGreen
+	}
+}
+
+// isTerminal reports whether w is a character device and NO_COLOR is not set.
+func isTerminal(w io.Writer) bool {
+	if _, ok := os.LookupEnv("NO_COLOR"); ok {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,71 @@
+package ctxlog
+
+import (
+	"container/list"
+	"sync"
+)
+
+// maxCountKeys is the number of distinct messages counted by msgCounter,
+// least recently printed ones are forgotten first and counted from 1 again.
+const maxCountKeys = 1024
+
+// msgCounter counts printed messages by msg, see CountByMsg, WarnOnFormatVerbs and Sample.
+type msgCounter struct {
+	mu   sync.Mutex
+	msgs map[string]*list.Element // msg -> *msgCount
+	lru  list.List
+}
+
+type msgCount struct {
+	msg     string
+	n       uint64
+	dropped uint64 // see sample
+}
+
+func newMsgCounter() *msgCounter {
+	return &msgCounter{msgs: make(map[string]*list.Element)}
+}
+
+// count returns the number of messages with msg printed so far, including the current one.
+func (c *msgCounter) count(msg string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mc := c.get(msg)
+	mc.n++
+	return mc.n
+}
+
+// sample counts message msg and reports whether it is 1 of every n messages with msg, see Sample.
+// If it is, number of messages dropped since the last one is returned as well.
+func (c *msgCounter) sample(msg string, n uint64) (dropped uint64, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mc := c.get(msg)
+	mc.n++
+	if (mc.n-1)%n != 0 {
+		mc.dropped++
+		return 0, false
+	}
+	dropped, mc.dropped = mc.dropped, 0
+	return dropped, true
+}
+
+// get returns counter of msg, adding it and forgetting the least recently used one if needed.
+// c.mu must be held.
+func (c *msgCounter) get(msg string) *msgCount {
+	if el, found := c.msgs[msg]; found {
+		c.lru.MoveToFront(el)
+		return el.Value.(*msgCount)
+	}
+
+	if c.lru.Len() >= maxCountKeys {
+		el := c.lru.Back()
+		c.lru.Remove(el)
+		delete(c.msgs, el.Value.(*msgCount).msg)
+	}
+	mc := &msgCount{msg: msg}
+	c.msgs[msg] = c.lru.PushFront(mc)
+	return mc
+}
@@ -0,0 +1,82 @@
+// Package ctxloghttp logs http requests with ctxlog.
+package ctxloghttp
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/kaey/ctxlog"
+)
+
+// Middleware returns handler which adds "method" and "path" fields to the request context with ctxlog.With,
+// so handlers print them with every message, calls next and prints "http request" message with
+// "status", "duration" and "bytes" fields. Level of the message is error for 5xx statuses,
+// warn for 4xx and info otherwise.
+func Middleware(log *ctxlog.Log, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ctx := ctxlog.With(r.Context(), ctxlog.Str("method", r.Method), ctxlog.Str("path", r.URL.Path))
+		rw := &responseWriter{ResponseWriter: w}
+
+		next.ServeHTTP(rw, r.WithContext(ctx))
+
+		if rw.status == 0 {
+			rw.status = http.StatusOK
+		}
+		log.Print(ctx, "http request",
+			ctxlog.Level(level(rw.status)),
+			ctxlog.Int("status", rw.status),
+			ctxlog.Dur("duration", time.Since(start)),
+			ctxlog.Int64("bytes", rw.bytes),
+		)
+	})
+}
+
+func level(status int) string {
+	switch {
+	case status >= 500:
+		return ctxlog.LevelError
+	case status >= 400:
+		return ctxlog.LevelWarn
+	default:
+		return ctxlog.LevelInfo
+	}
+}
+
+// responseWriter remembers status and number of bytes written.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		f.Flush()
+	}
+}
+
+// Unwrap returns the original ResponseWriter for http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,42 @@
+package ctxloghttp_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kaey/ctxlog"
+	"github.com/kaey/ctxlog/ctxloghttp"
+	"github.com/kaey/ctxlog/ctxlogtest"
+)
+
+func TestMiddleware(t *testing.T) {
+	log, c := ctxlogtest.NewCapture(ctxlog.NoTime())
+	h := ctxloghttp.Middleware(log, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Print(r.Context(), "not found")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("nope"))
+	}))
+
+	r := httptest.NewRequest("GET", "/users/1", nil).WithContext(context.Background())
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	entries := c.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected: %v, got: %v", 2, len(entries))
+	}
+	for k, expected := range map[string]any{"msg": "not found", "method": "GET", "path": "/users/1"} {
+		if got := entries[0][k]; expected != got {
+			t.Errorf("%s expected: %v, got: %v", k, expected, got)
+		}
+	}
+	for k, expected := range map[string]any{"msg": "http request", "level": "warn", "method": "GET", "status": 404.0, "bytes": 4.0} {
+		if got := entries[1][k]; expected != got {
+			t.Errorf("%s expected: %v, got: %v", k, expected, got)
+		}
+	}
+	if _, ok := entries[1]["duration"]; !ok {
+		t.Errorf("expected: %v, got: %v", "duration", entries[1])
+	}
+}
@@ -0,0 +1,85 @@
+// Package ctxlogtest helps to test code which logs with ctxlog.
+package ctxlogtest
+
+import (
+	"bytes"
+	"encoding/json"
+	"sync"
+
+	"github.com/kaey/ctxlog"
+)
+
+// Capture stores messages printed by the Log returned together with it from NewCapture.
+// It is safe for concurrent use.
+type Capture struct {
+	mu      sync.Mutex
+	entries []map[string]any
+	buf     []byte
+}
+
+// NewCapture returns Log which prints to returned Capture.
+// opts are passed to ctxlog.New, output format is always json.
+func NewCapture(opts ...ctxlog.Option) (*ctxlog.Log, *Capture) {
+	c := new(Capture)
+	opts = append(opts[:len(opts):len(opts)], ctxlog.Printer(ctxlog.FormatJSON))
+	return ctxlog.New(c, opts...), c
+}
+
+// Write decodes json lines written to c.
+// Lines which can't be decoded are stored as {"ctxlogtest_error": err, "line": line}.
+func (c *Capture) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.buf = append(c.buf, p...)
+	for {
+		i := bytes.IndexByte(c.buf, '\n')
+		if i < 0 {
+			break
+		}
+
+		line := c.buf[:i]
+		var m map[string]any
+		if err := json.Unmarshal(line, &m); err != nil {
+			m = map[string]any{"ctxlogtest_error": err.Error(), "line": string(line)}
+		}
+		c.entries = append(c.entries, m)
+		c.buf = c.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Entries returns decoded messages in order they were printed.
+func (c *Capture) Entries() []map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]map[string]any, len(c.entries))
+	copy(entries, c.entries)
+	return entries
+}
+
+// Last returns the last printed message or nil.
+func (c *Capture) Last() map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) == 0 {
+		return nil
+	}
+	return c.entries[len(c.entries)-1]
+}
+
+// LastField returns value of field key of the last printed message or nil.
+// Numbers are float64, as decoded by encoding/json.
+func (c *Capture) LastField(key string) any {
+	return c.Last()[key]
+}
+
+// Reset removes all stored messages.
+func (c *Capture) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = nil
+}
@@ -0,0 +1,42 @@
+package ctxlogtest_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/kaey/ctxlog"
+	"github.com/kaey/ctxlog/ctxlogtest"
+)
+
+func TestCapture(t *testing.T) {
+	log, c := ctxlogtest.NewCapture(ctxlog.Logfmt())
+	ctx := ctxlog.With(context.Background(), ctxlog.Int("n", 1))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			log.Print(ctx, "concurrent")
+		}()
+	}
+	wg.Wait()
+	log.Print(ctx, "failed", ctxlog.Error(errors.New("broken pipe")))
+
+	if n := len(c.Entries()); n != 11 {
+		t.Errorf("expected: %v entries, got: %v", 11, n)
+	}
+	if v := c.LastField("error"); v != "broken pipe" {
+		t.Errorf("expected: %v, got: %v", "broken pipe", v)
+	}
+	if v := c.LastField("n"); v != 1.0 {
+		t.Errorf("expected: %v, got: %v", 1.0, v)
+	}
+
+	c.Reset()
+	if v := c.LastField("msg"); v != nil {
+		t.Errorf("expected: %v, got: %v", nil, v)
+	}
+}
@@ -0,0 +1,144 @@
+package ctxlog
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// maxDedupKeys is the number of distinct lines remembered by deduper,
+// least recently printed ones are forgotten first.
+const maxDedupKeys = 1024
+
+// deduper suppresses lines repeated within a time window, see Dedup.
+type deduper struct {
+	window time.Duration
+
+	mu   sync.Mutex
+	keys map[string]*list.Element // key -> *dedupLine
+	lru  list.List
+	// last is the most recently checked line, only it can have suppressed lines not reported yet,
+	// they are reported when another line is printed, when the window closes or on Sync.
+	last  *dedupLine
+	gen   uint64 // incremented when suppressed lines of last are reported
+	timer *time.Timer
+}
+
+type dedupLine struct {
+	key      string
+	start    time.Time
+	repeated uint64
+	first    dedupSource // the first suppressed line
+}
+
+// dedupSource is a suppressed message, printed again with "repeated" field to report suppressed lines.
+type dedupSource struct {
+	l      *Log
+	ctx    context.Context
+	cd     *ctxdata
+	fields []Field
+	msg    string
+}
+
+func newDeduper(window time.Duration) *deduper {
+	return &deduper{window: window, keys: make(map[string]*list.Element)}
+}
+
+// check reports whether line with key should be printed at now.
+// If it should, number of lines suppressed since the last printed one is returned as well.
+// src is the line, it is kept if it is the first suppressed one.
+// Suppressed lines of another line which must be reported before this line are returned as pending, see report.
+func (d *deduper) check(key string, now time.Time, src func() dedupSource) (repeated uint64, pending *dedupLine, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.last != nil && d.last.key != key {
+		pending = d.take()
+	}
+
+	if el, found := d.keys[key]; found {
+		d.lru.MoveToFront(el)
+		line := el.Value.(*dedupLine)
+		d.last = line
+		if now.Sub(line.start) < d.window {
+			line.repeated++
+			if line.repeated == 1 {
+				line.first = src()
+				gen := d.gen
+				d.timer = time.AfterFunc(line.start.Add(d.window).Sub(now), func() { d.expire(gen) })
+			}
+			return 0, pending, false
+		}
+		repeated = line.repeated
+		d.take()
+		line.start = now
+		return repeated, pending, true
+	}
+
+	if d.lru.Len() >= maxDedupKeys {
+		el := d.lru.Back()
+		d.lru.Remove(el)
+		delete(d.keys, el.Value.(*dedupLine).key)
+	}
+	line := &dedupLine{key: key, start: now}
+	d.keys[key] = d.lru.PushFront(line)
+	d.last = line
+	return 0, pending, true
+}
+
+// take returns copy of the last line if it has suppressed lines and resets their count.
+// The window of the line is not reset, so it is still suppressed until the window closes.
+func (d *deduper) take() *dedupLine {
+	line := d.last
+	if line == nil || line.repeated == 0 {
+		return nil
+	}
+	taken := *line
+	line.repeated, line.first = 0, dedupSource{}
+	d.gen++
+	if d.timer != nil {
+		d.timer.Stop()
+		d.timer = nil
+	}
+	return &taken
+}
+
+// expire reports suppressed lines when the window closes.
+func (d *deduper) expire(gen uint64) {
+	d.mu.Lock()
+	var pending *dedupLine
+	if d.gen == gen {
+		pending = d.take()
+	}
+	d.mu.Unlock()
+	pending.report()
+}
+
+// flush reports suppressed lines not reported yet, see Log.Sync.
+func (d *deduper) flush() {
+	d.mu.Lock()
+	pending := d.take()
+	d.mu.Unlock()
+	pending.report()
+}
+
+// report prints the first suppressed line with "repeated" field, it does nothing if line is nil.
+func (line *dedupLine) report() {
+	if line == nil {
+		return
+	}
+	src := line.first
+	l := *src.l
+	l.deduper = nil
+	l.print(src.ctx, src.cd, append(src.fields, Int64("repeated", int64(line.repeated))), 0, src.msg)
+}
+
+// dedupKey returns key identifying line of e: its msg and error message,
+// which is used instead of the error field, as ErrorMarshaler may print it as any value.
+func dedupKey(e *entry) string {
+	if e.errMsg != "" {
+		return e.msg + "\x00" + e.errMsg
+	}
+	return e.msg
+}
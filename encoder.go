@@ -0,0 +1,99 @@
+package ctxlog
+
+import "io"
+
+// Encoder encodes a message to w, see WithEncoder.
+// fields contain all fields of the message in order they are printed by default:
+// "level" first if the message has level, then other fields, then time of the message under the time key,
+// or all of them sorted by key if SortAllKeys is set.
+// If Encode returns error, line describing the error is written instead of anything Encode wrote.
+type Encoder interface {
+	Encode(w io.Writer, fields []Field, msg string) error
+}
+
+// JSONEncoder encodes messages as json lines, the same way as FormatJSON with default TimeFormat.
+// It is mostly useful as an example of Encoder, FormatJSON is faster.
+// Used with WithEncoder, it prints msg under the key set by MsgKey and respects OmitEmptyMsg.
+type JSONEncoder struct{}
+
+// Encode implements Encoder, msg is printed under "msg" key.
+func (enc JSONEncoder) Encode(w io.Writer, fields []Field, msg string) error {
+	return enc.encode(w, fields, "msg", msg)
+}
+
+// encode encodes message with msg printed under msgKey, or omitted if msgKey is empty.
+func (JSONEncoder) encode(w io.Writer, fields []Field, msgKey, msg string) error {
+	b := make([]byte, 0, 256)
+	b = append(b, '{')
+	if msgKey != "" {
+		b = appendJSONString(b, msgKey)
+		b = append(b, ':')
+		b = appendJSONString(b, msg)
+	}
+	for i, f := range fields {
+		if i > 0 || msgKey != "" {
+			b = append(b, ',')
+		}
+		b = appendJSONField(b, f)
+	}
+	b = append(b, '}', '\n')
+	_, err := w.Write(b)
+	return err
+}
+
+// keyEncoder is implemented by encoders which print msg under the key of the Log, see JSONEncoder.
+type keyEncoder interface {
+	encode(w io.Writer, fields []Field, msgKey, msg string) error
+}
+
+// Key returns key of f.
+func (f Field) Key() string {
+	return f.key
+}
+
+// Value returns value of f, objects made by groups are returned as map[string]any.
+func (f Field) Value() any {
+	return f.value()
+}
+
+// appendEncoded appends e encoded with enc to b.
+func (l *Log) appendEncoded(b []byte, e *entry, enc Encoder) ([]byte, error) {
+	fields := make([]Field, 0, len(e.fields)+2)
+	i := 0
+	keys, n := l.ownKeys(e)
+	for _, k := range keys[:n] {
+		fields = append(fields, e.fields[i:k.at]...)
+		i = k.at
+
+		switch k.kind {
+		case keyLevel:
+			if rank, ok := l.numericLevel(e.level); ok {
+				fields = append(fields, Int(e.levelKey, rank))
+			} else {
+				fields = append(fields, Str(e.levelKey, e.level))
+			}
+		case keyTime:
+			fields = append(fields, Field{key: e.timeKey, val: e.time})
+		}
+	}
+	fields = append(fields, e.fields[i:]...)
+
+	w := &appendWriter{b: b}
+	var err error
+	if ke, ok := enc.(keyEncoder); ok {
+		err = ke.encode(w, fields, e.msgKey, e.msg)
+	} else {
+		err = enc.Encode(w, fields, e.msg)
+	}
+	return w.b, err
+}
+
+// appendWriter appends written bytes to b.
+type appendWriter struct {
+	b []byte
+}
+
+func (w *appendWriter) Write(p []byte) (int, error) {
+	w.b = append(w.b, p...)
+	return len(p), nil
+}
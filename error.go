@@ -0,0 +1,21 @@
+package ctxlog
+
+import "errors"
+
+// maxErrorChain limits length of error chain, it guards against cyclic wrapping.
+const maxErrorChain = 32
+
+// errorChain returns messages of err and all errors it wraps.
+// Nil is returned if err doesn't wrap anything.
+func errorChain(err error) []string {
+	next := errors.Unwrap(err)
+	if next == nil {
+		return nil
+	}
+
+	chain := []string{err.Error()}
+	for ; next != nil && len(chain) < maxErrorChain; next = errors.Unwrap(next) {
+		chain = append(chain, next.Error())
+	}
+	return chain
+}
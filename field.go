@@ -0,0 +1,210 @@
+package ctxlog
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+)
+
+type fieldKind uint8
+
+const (
+	kindAny fieldKind = iota
+	kindString
+	kindInt64
+	kindFloat64
+	kindBool
+	kindDuration
+	kindObject // val is []Field
+	kindArray  // val is []any, []int or []string
+	kindBytes  // val is []byte, encoded as set by BytesEncoding
+	kindSkip   // num is number of frames to skip, the field is not printed
+	kindHumanDuration
+	kindHumanBytes
+)
+
+// Field is a key-value pair attached to a message.
+// Typed constructors like Int or Str store value without boxing it into interface.
+type Field struct {
+	key  string
+	kind fieldKind
+	num  int64
+	str  string
+	val  any
+}
+
+func Value(k string, v any) Field {
+	return Field{key: k, val: v}
+}
+
+func Error(err error) Field {
+	return Field{key: "error", val: err}
+}
+
+func Time(t time.Time) Field {
+	return Field{key: "time", val: t}
+}
+
+func Str(k string, v string) Field {
+	return Field{key: k, kind: kindString, str: v}
+}
+
+func Int(k string, v int) Field {
+	return Field{key: k, kind: kindInt64, num: int64(v)}
+}
+
+func Int64(k string, v int64) Field {
+	return Field{key: k, kind: kindInt64, num: v}
+}
+
+func Float64(k string, v float64) Field {
+	return Field{key: k, kind: kindFloat64, num: int64(math.Float64bits(v))}
+}
+
+func Bool(k string, v bool) Field {
+	f := Field{key: k, kind: kindBool}
+	if v {
+		f.num = 1
+	}
+	return f
+}
+
+// Dur is encoded as a string like "1.5s".
+func Dur(k string, d time.Duration) Field {
+	return Field{key: k, kind: kindDuration, num: int64(d)}
+}
+
+// Object is a field encoded as a nested object with fields fs, like {"user":{"id":7,"name":"x"}}.
+// Repeated keys within fs are resolved the same way as keys of a single Print call.
+func Object(k string, fs ...Field) Field {
+	return Field{key: k, kind: kindObject, val: fs}
+}
+
+// Array is a field encoded as an array of vals, see Ints and Strs for typed versions.
+func Array(k string, vals ...any) Field {
+	return Field{key: k, kind: kindArray, val: vals}
+}
+
+func Ints(k string, vals ...int) Field {
+	return Field{key: k, kind: kindArray, val: vals}
+}
+
+func Strs(k string, vals ...string) Field {
+	return Field{key: k, kind: kindArray, val: vals}
+}
+
+// Bytes is a field encoded as a hex or base64 string, see BytesEncoding.
+// If b is longer than the limit, it is truncated and its length is printed as key_len field.
+func Bytes(k string, b []byte) Field {
+	return Field{key: k, kind: kindBytes, val: b}
+}
+
+// Skip is passed to Print by wrappers around ctxlog, so that caller and stack added by WithCaller
+// and StackOnError point to the caller of the wrapper and not to the wrapper itself.
+// n is the number of wrapper functions between the caller and Print, the field itself is not printed.
+func Skip(n int) Field {
+	return Field{kind: kindSkip, num: int64(n)}
+}
+
+// HumanDuration is printed as a string like "1.5s" if Humanize is set or format is console,
+// as a number of nanoseconds otherwise.
+func HumanDuration(k string, d time.Duration) Field {
+	return Field{key: k, kind: kindHumanDuration, num: int64(d)}
+}
+
+// HumanBytes is printed as a string like "1.2 MB" if Humanize is set or format is console,
+// as a number of bytes otherwise.
+func HumanBytes(k string, n int64) Field {
+	return Field{key: k, kind: kindHumanBytes, num: n}
+}
+
+// Lazy is a field whose value is returned by fn. fn is called when the message is printed,
+// so it is not called for messages dropped by MinLevel or Sample. fn is called at most once,
+// even if the field is added to context and printed with several messages.
+// Panic in fn is recovered and printed as the value.
+func Lazy(k string, fn func() any) Field {
+	return Field{key: k, val: &lazyValue{fn: fn}}
+}
+
+type lazyValue struct {
+	once sync.Once
+	fn   func() any
+	val  any
+}
+
+func (v *lazyValue) LogValue() any {
+	v.once.Do(func() {
+		defer func() {
+			if r := recover(); r != nil {
+				v.val = fmt.Sprintf("!PANIC: %v", r)
+			}
+		}()
+		v.val = v.fn()
+	})
+	return v.val
+}
+
+// LogValuer is implemented by types which should be printed as a different value,
+// like a user printed as its id only or a secret printed masked.
+// If LogValue returns another LogValuer, it is resolved as well, up to maxLogValuerDepth times.
+type LogValuer interface {
+	LogValue() any
+}
+
+const maxLogValuerDepth = 8
+
+// resolve replaces value implementing LogValuer with the value it returns.
+// Panic in LogValue is recovered and printed as the value.
+func (f Field) resolve() (rf Field) {
+	if f.kind != kindAny {
+		return f
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			rf = Value(f.key, fmt.Sprintf("!PANIC: %v", r))
+		}
+	}()
+	for i := 0; i < maxLogValuerDepth; i++ {
+		lv, ok := f.val.(LogValuer)
+		if !ok {
+			break
+		}
+		f.val = lv.LogValue()
+	}
+	return f
+}
+
+// value returns field value as it should be encoded.
+func (f Field) value() any {
+	switch f.kind {
+	case kindString:
+		return f.str
+	case kindInt64, kindHumanDuration, kindHumanBytes:
+		return f.num
+	case kindFloat64:
+		return math.Float64frombits(uint64(f.num))
+	case kindBool:
+		return f.num == 1
+	case kindDuration:
+		return time.Duration(f.num).String()
+	case kindObject:
+		fs := f.val.([]Field)
+		m := make(map[string]any, len(fs))
+		for _, f := range fs {
+			m[f.key] = f.value()
+		}
+		return m
+	default:
+		return f.val
+	}
+}
+
+// string returns field value if it is a string.
+func (f Field) string() (string, bool) {
+	if f.kind == kindString {
+		return f.str, true
+	}
+	s, ok := f.val.(string)
+	return s, ok
+}
@@ -0,0 +1,105 @@
+// Package grpclog logs grpc requests with ctxlog.
+package grpclog
+
+import (
+	"context"
+	"time"
+
+	"github.com/kaey/ctxlog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// UnaryServerInterceptor returns interceptor which adds "grpc_method" field to the request context
+// with ctxlog.With, so handlers print it with every message, calls the handler and prints "grpc request"
+// message with "grpc_code" and "duration" fields.
+// Values of incoming metadata keys, like "x-request-id", are added as fields with the same keys.
+// Panics in the handler are printed with Log.RecoverSwallow and returned as codes.Internal error.
+func UnaryServerInterceptor(log *ctxlog.Log, keys ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		start := time.Now()
+		ctx = with(ctx, info.FullMethod, keys)
+		err = call(ctx, log, func() error {
+			resp, err = handler(ctx, req)
+			return err
+		})
+		printRequest(ctx, log, err, start)
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is UnaryServerInterceptor for streams,
+// the context returned by Context method of the stream has the fields added.
+func StreamServerInterceptor(log *ctxlog.Log, keys ...string) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx := with(ss.Context(), info.FullMethod, keys)
+		err := call(ctx, log, func() error {
+			return handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+		})
+		printRequest(ctx, log, err, start)
+		return err
+	}
+}
+
+func with(ctx context.Context, method string, keys []string) context.Context {
+	fields := make([]ctxlog.Field, 0, len(keys)+1)
+	fields = append(fields, ctxlog.Str("grpc_method", method))
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, k := range keys {
+		if v := md.Get(k); len(v) > 0 {
+			fields = append(fields, ctxlog.Str(k, v[0]))
+		}
+	}
+	return ctxlog.With(ctx, fields...)
+}
+
+// call calls f, converting panic to codes.Internal error.
+func call(ctx context.Context, log *ctxlog.Log, f func() error) (err error) {
+	panicked := true
+	defer func() {
+		if panicked {
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+	defer log.RecoverSwallow(ctx)
+
+	err = f()
+	panicked = false
+	return err
+}
+
+func printRequest(ctx context.Context, log *ctxlog.Log, err error, start time.Time) {
+	code := status.Code(err)
+	fields := []ctxlog.Field{
+		ctxlog.Level(level(code)),
+		ctxlog.Str("grpc_code", code.String()),
+		ctxlog.Dur("duration", time.Since(start)),
+	}
+	if err != nil {
+		fields = append(fields, ctxlog.Error(err))
+	}
+	log.Print(ctx, "grpc request", fields...)
+}
+
+func level(code codes.Code) string {
+	switch code {
+	case codes.OK:
+		return ctxlog.LevelInfo
+	case codes.Unknown, codes.DeadlineExceeded, codes.Unimplemented, codes.Internal, codes.Unavailable, codes.DataLoss:
+		return ctxlog.LevelError
+	default:
+		return ctxlog.LevelWarn
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
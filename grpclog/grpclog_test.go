@@ -0,0 +1,103 @@
+package grpclog_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kaey/ctxlog"
+	"github.com/kaey/ctxlog/ctxlogtest"
+	"github.com/kaey/ctxlog/grpclog"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	log, c := ctxlogtest.NewCapture(ctxlog.NoTime())
+	i := grpclog.UnaryServerInterceptor(log, "x-request-id")
+	info := &grpc.UnaryServerInfo{FullMethod: "/pkg.Svc/Get"}
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-request-id", "abc"))
+
+	resp, err := i(ctx, "req", info, func(ctx context.Context, req any) (any, error) {
+		log.Print(ctx, "handling")
+		return "resp", nil
+	})
+	if err != nil || resp != "resp" {
+		t.Fatalf("expected: %v, got: %v %v", "resp", resp, err)
+	}
+
+	entries := c.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected: %v, got: %v", 2, len(entries))
+	}
+	for k, expected := range map[string]any{"msg": "handling", "grpc_method": "/pkg.Svc/Get", "x-request-id": "abc"} {
+		if got := entries[0][k]; expected != got {
+			t.Errorf("%s expected: %v, got: %v", k, expected, got)
+		}
+	}
+	for k, expected := range map[string]any{"msg": "grpc request", "level": "info", "grpc_code": "OK", "x-request-id": "abc"} {
+		if got := entries[1][k]; expected != got {
+			t.Errorf("%s expected: %v, got: %v", k, expected, got)
+		}
+	}
+}
+
+func TestUnaryServerInterceptorPanic(t *testing.T) {
+	log, c := ctxlogtest.NewCapture(ctxlog.NoTime())
+	i := grpclog.UnaryServerInterceptor(log)
+	info := &grpc.UnaryServerInfo{FullMethod: "/pkg.Svc/Get"}
+
+	_, err := i(context.Background(), "req", info, func(ctx context.Context, req any) (any, error) {
+		panic("boom")
+	})
+	if expected, got := codes.Internal, status.Code(err); expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	entries := c.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected: %v, got: %v", 2, len(entries))
+	}
+	if expected, got := "boom", entries[0]["panic"]; expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	for k, expected := range map[string]any{"msg": "grpc request", "level": "error", "grpc_code": "Internal"} {
+		if got := entries[1][k]; expected != got {
+			t.Errorf("%s expected: %v, got: %v", k, expected, got)
+		}
+	}
+}
+
+type stream struct {
+	grpc.ServerStream
+}
+
+func (stream) Context() context.Context { return context.Background() }
+
+func TestStreamServerInterceptor(t *testing.T) {
+	log, c := ctxlogtest.NewCapture(ctxlog.NoTime())
+	i := grpclog.StreamServerInterceptor(log)
+	info := &grpc.StreamServerInfo{FullMethod: "/pkg.Svc/Watch"}
+
+	err := i(nil, stream{}, info, func(srv any, ss grpc.ServerStream) error {
+		log.Print(ss.Context(), "streaming")
+		return status.Error(codes.NotFound, "no such thing")
+	})
+	if expected, got := codes.NotFound, status.Code(err); expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	entries := c.Entries()
+	if len(entries) != 2 {
+		t.Fatalf("expected: %v, got: %v", 2, len(entries))
+	}
+	if expected, got := "/pkg.Svc/Watch", entries[0]["grpc_method"]; expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	for k, expected := range map[string]any{"level": "warn", "grpc_code": "NotFound"} {
+		if got := entries[1][k]; expected != got {
+			t.Errorf("%s expected: %v, got: %v", k, expected, got)
+		}
+	}
+}
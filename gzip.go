@@ -0,0 +1,93 @@
+package ctxlog
+
+import (
+	"compress/gzip"
+	"io"
+	"sync"
+	"time"
+)
+
+// GzipWriter returns writer which compresses lines with gzip before writing them to w.
+// Compressed data is flushed to w after every write, so nothing is lost on crash,
+// at the cost of compression ratio, see GzipWriterInterval.
+// Close finalizes the gzip stream, w is not closed.
+func GzipWriter(w io.Writer) io.WriteCloser {
+	return GzipWriterInterval(w, 0)
+}
+
+// GzipWriterInterval is GzipWriter which flushes compressed data at most once per interval,
+// which compresses better. Data written less than interval ago is lost on crash
+// unless Sync is called.
+func GzipWriterInterval(w io.Writer, interval time.Duration) io.WriteCloser {
+	return &gzipWriter{w: w, zw: gzip.NewWriter(w), interval: interval}
+}
+
+type gzipWriter struct {
+	w        io.Writer
+	interval time.Duration
+
+	mu     sync.Mutex
+	zw     *gzip.Writer
+	timer  *time.Timer
+	closed bool
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := w.zw.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if w.interval <= 0 {
+		return n, w.zw.Flush()
+	}
+	if w.timer == nil {
+		w.timer = time.AfterFunc(w.interval, w.flush)
+	}
+	return n, nil
+}
+
+func (w *gzipWriter) flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.timer = nil
+	if !w.closed {
+		w.zw.Flush()
+	}
+}
+
+// Sync flushes compressed data and syncs w, see Log.Sync.
+func (w *gzipWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	if err := w.zw.Flush(); err != nil {
+		return err
+	}
+	return syncWriter(w.w)
+}
+
+// Close writes the end of the gzip stream.
+func (w *gzipWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	return w.zw.Close()
+}
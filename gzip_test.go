@@ -0,0 +1,64 @@
+package ctxlog_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/kaey/ctxlog"
+)
+
+func TestGzipWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := ctxlog.GzipWriter(buf)
+	log := ctxlog.New(w, ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	log.Print(ctx, "foo")
+	// Line is readable before the stream is closed.
+	if got := gunzip(t, buf.Bytes()); got != "msg=foo\n" {
+		t.Errorf("expected: %v, got: %v", "msg=foo\n", got)
+	}
+
+	log.Print(ctx, "bar")
+	w.Close()
+
+	expected := "msg=foo\nmsg=bar\n"
+	got := gunzip(t, buf.Bytes())
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestGzipWriterInterval(t *testing.T) {
+	buf := new(bytes.Buffer)
+	w := ctxlog.GzipWriterInterval(ctxlog.MuWriter(buf), time.Hour)
+	log := ctxlog.New(w, ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	log.Print(ctx, "foo")
+	log.Sync()
+
+	expected := "msg=foo\n"
+	got := gunzip(t, buf.Bytes())
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	w.Close()
+}
+
+// gunzip decompresses p, which may be an unfinished stream.
+func gunzip(t *testing.T, p []byte) string {
+	zr, err := gzip.NewReader(bytes.NewReader(p))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(zr)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		t.Fatal(err)
+	}
+	return string(out)
+}
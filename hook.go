@@ -0,0 +1,122 @@
+package ctxlog
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// mapPool is the default pool of maps passed to hooks, see PoolHints.
+var mapPool sync.Pool = sync.Pool{
+	New: func() any {
+		return make(map[string]any, 10)
+	},
+}
+
+// runHooks calls hooks registered with Hook and applies changes they made to fields of e.
+// The map passed to hooks is reused for the next message, unless a hook registered with RetainingHook may keep it.
+func (l *Log) runHooks(ctx context.Context, e *entry) {
+	if len(l.hooks) == 0 {
+		return
+	}
+
+	var m map[string]any
+	if l.retainHook {
+		m = make(map[string]any, len(e.fields))
+	} else {
+		m = l.mapPool.Get().(map[string]any)
+		defer func() {
+			clear(m)
+			l.mapPool.Put(m)
+		}()
+	}
+
+	for _, f := range e.fields {
+		m[f.key] = f.value()
+	}
+	for _, fn := range l.hooks {
+		runHook(fn, ctx, e.time, e.level, e.msg, m)
+	}
+
+	e.fields = applyMap(e.fields[:0], e.fields, m)
+}
+
+// filter reports whether e passes filters registered with Filter.
+func (l *Log) filter(ctx context.Context, e *entry) bool {
+	if len(l.filters) == 0 {
+		return true
+	}
+
+	m := l.mapPool.Get().(map[string]any)
+	defer func() {
+		clear(m)
+		l.mapPool.Put(m)
+	}()
+
+	for _, f := range e.fields {
+		m[f.key] = f.value()
+	}
+	for _, fn := range l.filters {
+		if !runFilter(fn, ctx, e.level, e.msg, m) {
+			return false
+		}
+	}
+	return true
+}
+
+// runFilter calls fn, panic in fn is recovered and the message is kept.
+func runFilter(fn func(ctx context.Context, level, msg string, fields map[string]any) bool, ctx context.Context, level, msg string, m map[string]any) (ok bool) {
+	defer func() {
+		if recover() != nil {
+			ok = true
+		}
+	}()
+	return fn(ctx, level, msg, m)
+}
+
+func runHook(fn func(ctx context.Context, t time.Time, level, msg string, fields map[string]any), ctx context.Context, t time.Time, level, msg string, m map[string]any) {
+	defer func() {
+		recover()
+	}()
+	fn(ctx, t, level, msg, m)
+}
+
+// applyMap appends fields of m to dst, keeping order of fs for keys present in both.
+// Keys which are only in m are appended sorted. Objects are applied recursively.
+// dst may share memory with fs.
+func applyMap(dst, fs []Field, m map[string]any) []Field {
+	n := 0
+	for _, f := range fs {
+		v, ok := m[f.key]
+		if !ok {
+			continue
+		}
+		n++
+
+		if nested, ok := v.(map[string]any); ok && f.kind == kindObject {
+			dst = append(dst, Field{key: f.key, kind: kindObject, val: applyMap(nil, f.val.([]Field), nested)})
+		} else if (f.kind == kindHumanDuration || f.kind == kindHumanBytes) && v == any(f.num) {
+			// Unchanged, keep it human readable.
+			dst = append(dst, f)
+		} else {
+			dst = append(dst, Value(f.key, v))
+		}
+	}
+
+	if n == len(m) {
+		return dst
+	}
+
+	keys := make([]string, 0, len(m)-n)
+	for k := range m {
+		if !hasKey(fs, k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		dst = append(dst, Value(k, m[k]))
+	}
+	return dst
+}
@@ -0,0 +1,316 @@
+package ctxlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+	"unicode/utf8"
+)
+
+// appendJSON appends e to b as a json line.
+// Keys are written in order: msg, level, fields in order they were collected, time,
+// or sorted if SortAllKeys is set, see ownKeys.
+// msg is omitted if e.msgKey is empty.
+func (l *Log) appendJSON(b []byte, e *entry) ([]byte, error) {
+	start := len(b)
+	b = append(b, '{')
+	i := 0
+	keys, n := l.ownKeys(e)
+	for _, k := range keys[:n] {
+		for ; i < k.at; i++ {
+			b = append(b, ',')
+			b = appendJSONField(b, e.fields[i])
+		}
+
+		b = append(b, ',')
+		b = appendJSONString(b, k.key)
+		b = append(b, ':')
+		switch k.kind {
+		case keyMsg:
+			b = appendJSONString(b, e.msg)
+		case keyLevel:
+			if rank, ok := l.numericLevel(e.level); ok {
+				b = strconv.AppendInt(b, int64(rank), 10)
+			} else {
+				b = appendJSONString(b, e.level)
+			}
+		case keyTime:
+			b = l.appendJSONEntryTime(b, e.time)
+		}
+	}
+	for ; i < len(e.fields); i++ {
+		b = append(b, ',')
+		b = appendJSONField(b, e.fields[i])
+	}
+
+	b = append(b, '}', '\n')
+	if b[start+1] == ',' {
+		b = append(b[:start+1], b[start+2:]...)
+	}
+	if l.prettyJSON {
+		b = appendJSONIndent(b, start)
+	}
+	return b, nil
+}
+
+// appendJSONIndent indents json line which starts at b[start], see PrettyJSON.
+func appendJSONIndent(b []byte, start int) []byte {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, b[start:], "", "  "); err != nil {
+		return b
+	}
+	return append(b[:start], buf.Bytes()...)
+}
+
+// appendJSONError appends line describing encoding error err instead of e.
+// It is the last resort, values which can't be encoded are normally replaced by appendJSONField.
+func (l *Log) appendJSONError(b []byte, e *entry, err error) []byte {
+	b = append(b, '{')
+	b = appendJSONString(b, l.msgKey)
+	b = append(b, ':')
+	b = appendJSONString(b, "ctxlog: json encode error")
+	b = append(b, ',')
+	b = appendJSONString(b, "error")
+	b = append(b, ':')
+	b = appendJSONString(b, err.Error())
+	b = append(b, ',')
+	b = appendJSONString(b, "orig_msg")
+	b = append(b, ':')
+	b = appendJSONString(b, e.msg)
+	if e.hasTime {
+		b = append(b, ',')
+		b = appendJSONString(b, l.timeKey)
+		b = append(b, ':')
+		if l.timeFormat == "" {
+			b = appendJSONString(b, e.time.Format(time.RFC3339))
+		} else {
+			b = l.appendJSONEntryTime(b, e.time)
+		}
+	}
+	b = append(b, '}', '\n')
+	return b
+}
+
+func appendJSONValue(b []byte, f Field) ([]byte, error) {
+	switch f.kind {
+	case kindString:
+		return appendJSONString(b, f.str), nil
+	case kindInt64, kindHumanDuration, kindHumanBytes:
+		return strconv.AppendInt(b, f.num, 10), nil
+	case kindFloat64:
+		return appendJSONFloat(b, math.Float64frombits(uint64(f.num)), 64)
+	case kindBool:
+		return strconv.AppendBool(b, f.num == 1), nil
+	case kindDuration:
+		return appendJSONString(b, time.Duration(f.num).String()), nil
+	case kindObject:
+		return appendJSONObject(b, f.val.([]Field))
+	case kindArray:
+		return appendJSONArray(b, f.val)
+	}
+
+	switch v := f.val.(type) {
+	case nil:
+		return append(b, "null"...), nil
+	case string:
+		return appendJSONString(b, v), nil
+	case bool:
+		return strconv.AppendBool(b, v), nil
+	case int:
+		return strconv.AppendInt(b, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(b, v, 10), nil
+	case int32:
+		return strconv.AppendInt(b, int64(v), 10), nil
+	case uint:
+		return strconv.AppendUint(b, uint64(v), 10), nil
+	case uint64:
+		return strconv.AppendUint(b, v, 10), nil
+	case uint32:
+		return strconv.AppendUint(b, uint64(v), 10), nil
+	case float64:
+		return appendJSONFloat(b, v, 64)
+	case float32:
+		return appendJSONFloat(b, float64(v), 32)
+	case time.Time:
+		return appendJSONTime(b, v), nil
+	default:
+		p, err := marshalJSON(v)
+		if err != nil {
+			return b, err
+		}
+		return append(b, p...), nil
+	}
+}
+
+// marshalJSON returns v encoded with json.Marshal.
+// Panic in a method of v is returned as error, so the field is printed as key_error, like in logfmt.
+func marshalJSON(v any) (p []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p, err = nil, fmt.Errorf("!PANIC: %v", r)
+		}
+	}()
+	return json.Marshal(v)
+}
+
+func appendJSONObject(b []byte, fs []Field) ([]byte, error) {
+	b = append(b, '{')
+	for i, f := range fs {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendJSONField(b, f)
+	}
+	return append(b, '}'), nil
+}
+
+// appendJSONArray appends vals, which is []any, []int or []string, as a json array.
+func appendJSONArray(b []byte, vals any) ([]byte, error) {
+	var err error
+	b = append(b, '[')
+	switch vals := vals.(type) {
+	case []int:
+		for i, v := range vals {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = strconv.AppendInt(b, int64(v), 10)
+		}
+	case []string:
+		for i, v := range vals {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			b = appendJSONString(b, v)
+		}
+	case []any:
+		for i, v := range vals {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			if b, err = appendJSONValue(b, Value("", v)); err != nil {
+				return b, err
+			}
+		}
+	}
+	return append(b, ']'), nil
+}
+
+// appendJSONField appends "key":value.
+// If value can't be encoded, "key_error":"error message" is appended instead,
+// so other fields of the message are not lost.
+func appendJSONField(b []byte, f Field) []byte {
+	start := len(b)
+	b = appendJSONString(b, f.key)
+	b = append(b, ':')
+	b, err := appendJSONValue(b, f)
+	if err != nil {
+		b = appendJSONString(b[:start], f.key+"_error")
+		b = append(b, ':')
+		b = appendJSONString(b, err.Error())
+	}
+	return b
+}
+
+// appendJSONEntryTime appends time of the message in format set by TimeFormat.
+func (l *Log) appendJSONEntryTime(b []byte, t time.Time) []byte {
+	switch l.timeFormat {
+	case "":
+		return appendJSONTime(b, t)
+	case TimeFormatUnix:
+		return strconv.AppendInt(b, t.Unix(), 10)
+	case TimeFormatUnixMilli:
+		return strconv.AppendInt(b, t.UnixMilli(), 10)
+	default:
+		return appendJSONString(b, t.Format(l.timeFormat))
+	}
+}
+
+func appendJSONTime(b []byte, t time.Time) []byte {
+	b = append(b, '"')
+	b = t.AppendFormat(b, time.RFC3339Nano)
+	return append(b, '"')
+}
+
+// appendJSONFloat formats f the same way encoding/json does.
+func appendJSONFloat(b []byte, f float64, bits int) ([]byte, error) {
+	if math.IsInf(f, 0) || math.IsNaN(f) {
+		return b, &json.UnsupportedValueError{Str: strconv.FormatFloat(f, 'g', -1, bits)}
+	}
+
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 {
+		if bits == 64 && (abs < 1e-6 || abs >= 1e21) || bits == 32 && (float32(abs) < 1e-6 || float32(abs) >= 1e21) {
+			format = 'e'
+		}
+	}
+	b = strconv.AppendFloat(b, f, format, -1, bits)
+	if format == 'e' {
+		// Clean up e-09 to e-9.
+		n := len(b)
+		if n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			b[n-2] = b[n-1]
+			b = b[:n-1]
+		}
+	}
+	return b, nil
+}
+
+const hex = "0123456789abcdef"
+
+// appendJSONString appends quoted s escaped the same way encoding/json does, including html characters.
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	start := 0
+	for i := 0; i < len(s); {
+		if c := s[i]; c < utf8.RuneSelf {
+			if c >= 0x20 && c != '"' && c != '\\' && c != '<' && c != '>' && c != '&' {
+				i++
+				continue
+			}
+			b = append(b, s[start:i]...)
+			switch c {
+			case '"', '\\':
+				b = append(b, '\\', c)
+			case '\b':
+				b = append(b, '\\', 'b')
+			case '\f':
+				b = append(b, '\\', 'f')
+			case '\n':
+				b = append(b, '\\', 'n')
+			case '\r':
+				b = append(b, '\\', 'r')
+			case '\t':
+				b = append(b, '\\', 't')
+			default:
+				b = append(b, '\\', 'u', '0', '0', hex[c>>4], hex[c&0xF])
+			}
+			i++
+			start = i
+			continue
+		}
+
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b = append(b, s[start:i]...)
+			b = append(b, `\ufffd`...)
+			i += size
+			start = i
+			continue
+		}
+		if r == '\u2028' || r == '\u2029' {
+			b = append(b, s[start:i]...)
+			b = append(b, '\\', 'u', '2', '0', '2', hex[r&0xF])
+			i += size
+			start = i
+			continue
+		}
+		i += size
+	}
+	b = append(b, s[start:]...)
+	return append(b, '"')
+}
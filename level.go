@@ -0,0 +1,92 @@
+package ctxlog
+
+import "context"
+
+// Known levels, ordered from least to most severe.
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+	LevelFatal = "fatal"
+)
+
+// Level sets level of the message.
+func Level(level string) Field {
+	return Str("level", level)
+}
+
+func levelRank(level string) int {
+	switch level {
+	case LevelDebug:
+		return 10
+	case LevelWarn:
+		return 30
+	case LevelError:
+		return 40
+	case LevelFatal:
+		return 50
+	default:
+		return 20
+	}
+}
+
+type levelkeytype struct{}
+
+var levelkey = levelkeytype{}
+
+// WithLevel returns new context in which messages are filtered by level instead of MinLevel of the Log,
+// for example to print debug messages of a single request. Level of the context overrides MinLevel
+// both ways, so it can be used to lower verbosity as well.
+func WithLevel(ctx context.Context, level string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, levelkey, levelRank(level))
+}
+
+// minLevelFor returns minimum level of messages printed with ctx, see WithLevel.
+func (l *Log) minLevelFor(ctx context.Context) int {
+	if ctx != nil {
+		if rank, ok := ctx.Value(levelkey).(int); ok {
+			return rank
+		}
+	}
+	return l.minLevel
+}
+
+// level returns level of the message, resolving repeated level fields the same way as collect.
+func (l *Log) level(cd *ctxdata) string {
+	for d := cd; d != nil; d = d.prev {
+		if lvl, ok := l.findLevel(d.fields); ok {
+			return lvl
+		}
+	}
+	lvl, _ := l.findLevel(l.fields)
+	return lvl
+}
+
+func (l *Log) findLevel(fs []Field) (string, bool) {
+	for i := range fs {
+		f := fs[i]
+		if l.lastWins {
+			f = fs[len(fs)-1-i]
+		}
+		if f.key == "level" {
+			lvl, _ := f.string()
+			return lvl, true
+		}
+	}
+	return "", false
+}
+
+// numericLevel returns number printed instead of level, see NumericLevel.
+func (l *Log) numericLevel(level string) (int, bool) {
+	if !l.numLevel {
+		return 0, false
+	}
+	if rank, ok := l.levelRanks[level]; ok {
+		return rank, true
+	}
+	return levelRank(level), true
+}
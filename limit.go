@@ -0,0 +1,89 @@
+package ctxlog
+
+import (
+	"slices"
+	"unicode/utf8"
+)
+
+const truncatedSuffix = "…(truncated)"
+
+// truncate shortens string values of fs and of objects in fs to maxFieldLen runes, see MaxFieldLen.
+func (l *Log) truncate(fs []Field) {
+	for i, f := range fs {
+		switch f.kind {
+		case kindObject:
+			l.truncate(f.val.([]Field))
+		case kindString:
+			if s, ok := truncateString(f.str, l.maxFieldLen); ok {
+				fs[i] = Str(f.key, s)
+			}
+		case kindAny:
+			if v, ok := f.val.(string); ok {
+				if s, ok := truncateString(v, l.maxFieldLen); ok {
+					fs[i] = Str(f.key, s)
+				}
+			}
+		}
+	}
+}
+
+// truncateString returns first n runes of s with truncatedSuffix if s is longer than n runes.
+func truncateString(s string, n int) (string, bool) {
+	if len(s) <= n || utf8.RuneCountInString(s) <= n {
+		return s, false
+	}
+	runes := 0
+	for i := range s {
+		if runes == n {
+			return s[:i] + truncatedSuffix, true
+		}
+		runes++
+	}
+	return s, false
+}
+
+// trimEntry appends e encoded for o to b with the largest fields removed until the line fits in maxLineBytes,
+// see MaxLineBytes. Msg, level, time and error are never removed.
+// It returns nil if the line should be dropped.
+func (l *Log) trimEntry(b []byte, e *entry, o output) ([]byte, error) {
+	if l.oversize == OversizeDrop {
+		return nil, nil
+	}
+
+	type sized struct{ i, size int }
+	var cands []sized
+	for i, f := range e.fields {
+		if f.key != "error" {
+			cands = append(cands, sized{i, len(appendJSONField(nil, f))})
+		}
+	}
+	slices.SortStableFunc(cands, func(a, b sized) int {
+		return b.size - a.size
+	})
+
+	te := *e
+	removed := make([]bool, len(e.fields))
+	var keys []string
+	for _, c := range cands {
+		removed[c.i] = true
+		keys = append(keys, e.fields[c.i].key)
+		te.fields = make([]Field, 0, len(e.fields)-len(keys)+1)
+		for i, f := range e.fields {
+			if !removed[i] {
+				te.fields = append(te.fields, f)
+			}
+		}
+		tf := Strs("truncated_fields", keys...)
+		if l.sortKeys {
+			// Keep fields sorted, see SortKeys.
+			i, _ := slices.BinarySearchFunc(te.fields, tf.key, compareKey)
+			te.fields = slices.Insert(te.fields, i, tf)
+		} else {
+			te.fields = append(te.fields, tf)
+		}
+		if p, err := l.appendEntry(b, &te, o); len(p) <= l.maxLineBytes {
+			return p, err
+		}
+	}
+	return nil, nil
+}
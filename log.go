@@ -2,33 +2,84 @@
 package ctxlog
 
 import (
-	"bytes"
 	"context"
-	"fmt"
+	"errors"
 	"io"
-	"runtime"
+	stdlog "log"
+	"os"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var log *Log
+// log is the Global logger, json to stderr by default.
+var log atomic.Pointer[Log]
 
+func init() {
+	log.Store(New(os.Stderr))
+}
+
+// Global sets logger used by package-level functions like Print, json to stderr by default.
+// Nil l makes them print nothing. It is safe to call concurrently with package-level functions.
 func Global(l *Log) {
-	log = l
+	log.Store(l)
 }
 
 // Print prints json line with Global logger using msg and fields, as well as any fields stored in context.
 func Print(ctx context.Context, msg string, fields ...Field) {
-	log.Print(ctx, msg, fields...)
+	log.Load().output(ctx, 2, msg, fields)
+}
+
+// Err prints err with Global logger and returns it, see Log.Err.
+func Err(ctx context.Context, err error, msg string, fields ...Field) error {
+	log.Load().err(ctx, err, msg, fields)
+	return err
+}
+
+// Fatal prints message with Global logger at fatal level and exits, see Log.Fatal.
+func Fatal(ctx context.Context, msg string, fields ...Field) {
+	log.Load().fatal(ctx, msg, fields)
+}
+
+// Panic prints message with Global logger at error level and panics with msg, see Log.Panic.
+func Panic(ctx context.Context, msg string, fields ...Field) {
+	log.Load().panic(ctx, msg, fields)
+}
+
+// Sync flushes Global logger, see Log.Sync.
+func Sync() error {
+	return log.Load().Sync()
+}
+
+// Writer returns io.Writer for Global logger, see Log.Writer.
+// Lines are printed with the logger set by Global at the time they are written.
+func Writer(ctx context.Context) io.WriteCloser {
+	return LevelWriter(ctx, LevelInfo)
+}
+
+// LevelWriter returns io.Writer for Global logger, see Log.LevelWriter.
+// Lines are printed with the logger set by Global at the time they are written.
+func LevelWriter(ctx context.Context, level string) io.WriteCloser {
+	return &writer{global: true, ctx: ctx, fields: []Field{Level(level)}}
 }
 
-// Writer returns io.Writer for Global logger which calls l.Print for every write to it.
-func Writer(ctx context.Context) io.Writer {
-	return log.Writer(ctx)
+// StdLogger returns *log.Logger for Global logger, see Log.StdLogger.
+// Lines are printed with the logger set by Global at the time they are written.
+func StdLogger(ctx context.Context, level string) *stdlog.Logger {
+	return stdlog.New(&writer{global: true, ctx: ctx, fields: []Field{Level(level)}, skip: stdLoggerSkip}, "", 0)
 }
 
 // With returns new context with specified fields added to it.
+//
+// If several fields have the same key, the most specific one is printed:
+// fields passed to Print override fields added with With, which override fields of the Log.
+// Fields added by later With calls override fields added by earlier ones.
+// Within a single call the first field wins, unless LastWins option is used.
+// Nil ctx is treated as context.Background().
 func With(ctx context.Context, fields ...Field) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
 	if len(fields) == 0 {
 		return ctx
 	}
@@ -37,80 +88,473 @@ func With(ctx context.Context, fields ...Field) context.Context {
 	return context.WithValue(ctx, ctxkey, &ctxdata{prev: cd, fields: fields})
 }
 
+// WithKV is With taking alternating keys and values, like WithKV(ctx, "a", 1, "b", 2).
+// Field arguments are added as they are. Argument in place of a key which is not a string
+// or a key without value is added as value of "BADKEY" field.
+func WithKV(ctx context.Context, args ...any) context.Context {
+	fields := make([]Field, 0, len(args)/2+1)
+	for i := 0; i < len(args); i++ {
+		switch key := args[i].(type) {
+		case Field:
+			fields = append(fields, key)
+		case string:
+			if i+1 < len(args) {
+				fields = append(fields, Value(key, args[i+1]))
+				i++
+				continue
+			}
+			fields = append(fields, Value("BADKEY", key))
+		default:
+			fields = append(fields, Value("BADKEY", key))
+		}
+	}
+	return With(ctx, fields...)
+}
+
+// FromContext returns fields stored in ctx by With and WithGroup from the oldest to the newest,
+// for example to copy them to another context with NewContext. Groups are returned as Object fields.
+// Repeated keys are resolved as when the message is printed without LastWins option,
+// so the result contains each key at most once. It returns false if ctx has no fields.
+func FromContext(ctx context.Context) ([]Field, bool) {
+	if ctx == nil {
+		return nil, false
+	}
+	cd, _ := ctx.Value(ctxkey).(*ctxdata)
+	if cd == nil {
+		return nil, false
+	}
+	return cd.flatten(), true
+}
+
+// NewContext returns new context in which fields stored by With are replaced with fields,
+// as if they were added by a single With call to ctx without any fields. Nil fields remove them.
+// Nil ctx is treated as context.Background().
+func NewContext(ctx context.Context, fields []Field) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var cd *ctxdata
+	if len(fields) > 0 {
+		cd = &ctxdata{fields: fields}
+	}
+	return context.WithValue(ctx, ctxkey, cd)
+}
+
+// MergeContexts returns new context derived from dst with fields stored in both dst and src,
+// for example to keep fields of a request in a goroutine detached from its context.
+// Fields of src override fields of dst with the same key, groups and objects with the same key are merged
+// the same way, see FromContext. Groups opened in dst by WithGroup stay open, so fields added to the result
+// by With are added to them. Only fields are taken from src, its values, deadline and cancellation are ignored.
+func MergeContexts(dst, src context.Context) context.Context {
+	if dst == nil {
+		dst = context.Background()
+	}
+	sfs, ok := FromContext(src)
+	if !ok || len(sfs) == 0 {
+		return dst
+	}
+	cd, _ := dst.Value(ctxkey).(*ctxdata)
+	if cd == nil {
+		return NewContext(dst, sfs)
+	}
+
+	var groups []string // open groups of dst, the innermost first
+	for d := cd; d != nil; d = d.prev {
+		if d.group != "" {
+			groups = append(groups, d.group)
+		}
+	}
+	merged := &ctxdata{fields: mergeFields(cd.flatten(), sfs)}
+	for i := len(groups) - 1; i >= 0; i-- {
+		fs, inner := splitObject(merged.fields, groups[i])
+		merged.fields = fs
+		merged = &ctxdata{prev: merged, group: groups[i], fields: inner}
+	}
+	return context.WithValue(dst, ctxkey, merged)
+}
+
+// mergeFields returns fields of dst and src, fields of src override fields of dst with the same key,
+// objects with the same key are merged recursively.
+func mergeFields(dst, src []Field) []Field {
+	fs := make([]Field, 0, len(dst)+len(src))
+	for _, f := range dst {
+		if !hasKey(src, f.key) {
+			fs = append(fs, f)
+		}
+	}
+	for _, f := range src {
+		if f.kind == kindObject {
+			for _, d := range dst {
+				if d.key == f.key && d.kind == kindObject {
+					f = Object(f.key, mergeFields(d.val.([]Field), f.val.([]Field))...)
+					break
+				}
+			}
+		}
+		fs = append(fs, f)
+	}
+	return fs
+}
+
+// splitObject returns fs without object with key and fields of the object.
+func splitObject(fs []Field, key string) ([]Field, []Field) {
+	for i, f := range fs {
+		if f.key == key && f.kind == kindObject {
+			return append(fs[:i:i], fs[i+1:]...), f.val.([]Field)
+		}
+	}
+	return fs, nil
+}
+
+// Fields returns fields stored in ctx by With as a map, for example to attach them to an error report.
+// Repeated keys are resolved and groups are nested the same way as when the message is printed,
+// level and time are included only if they were added with Level and Time fields.
+func Fields(ctx context.Context) map[string]any {
+	m := make(map[string]any)
+	if ctx == nil {
+		return m
+	}
+	cd, _ := ctx.Value(ctxkey).(*ctxdata)
+	if cd == nil {
+		return m
+	}
+
+	e := entryPool.Get().(*entry)
+	defer func() {
+		e.reset()
+		entryPool.Put(e)
+	}()
+
+	l := Log{noTime: true}
+	l.collect(ctx, e, cd)
+	if e.hasLevel {
+		m["level"] = e.level
+	}
+	if e.hasTime {
+		m["time"] = e.time
+	}
+	for _, f := range e.fields {
+		m[f.key] = f.value()
+	}
+	return m
+}
+
+// WithGroup returns new context in which fields added later by With or Print
+// are nested in an object with key name, like {"http":{"method":"GET"}}.
+// Fields added before stay where they are. Groups can be nested.
+// Error, time, level and caller fields are never nested.
+func WithGroup(ctx context.Context, name string) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if name == "" {
+		return ctx
+	}
+
+	cd, _ := ctx.Value(ctxkey).(*ctxdata)
+	return context.WithValue(ctx, ctxkey, &ctxdata{prev: cd, group: name})
+}
+
+// Log is safe for concurrent use. Messages are encoded outside of the lock,
+// only writes to the underlying writers are serialized, one Write call per message and writer.
+// With Async, messages queued at the same time are written with a single Write call.
 type Log struct {
-	fields []Field
-	w      io.Writer
+	fields    []Field
+	name      string
+	nop       bool
+	outputs   []output
+	mu        *sync.Mutex
+	minLevel  int
+	format    Format
+	encoder   Encoder
+	color     bool
+	colorSet  bool
+	caller    bool
+	lastWins  bool
+	sortKeys  bool
+	sortAll   bool // see SortAllKeys
+	mergeKeys []string
+	keyPolicy KeyPolicy
+	sampler   *sampler
+	dropErrs  bool // see SampledOutErrors
+	deduper   *deduper
+	seq       *atomic.Uint64
+	counter   *msgCounter
+	verbs     *msgCounter // messages with format verbs already warned about
+
+	maxStackDepth   int
+	structuredStack bool
+	stackOnError    bool
+	stacks          *stackCache
+
+	batch     *Batch
+	async     *async
+	asyncSize int
+	asyncDrop bool
+	bufPool   *sync.Pool
+	mapPool   *sync.Pool
+	entPool   *sync.Pool // entries of printed messages
+
+	msgKey     string
+	omitMsg    bool
+	levelKey   string
+	timeKey    string
+	timeFormat string
+	noTime     bool
+	lineEnd    string
+	prettyJSON bool
+	human      bool
+	clock      func() time.Time
+	numLevel   bool
+	levelRanks map[string]int
+
+	bytesFormat  BytesFormat
+	maxBytesLen  int
+	maxFieldLen  int
+	maxLineBytes int
+	oversize     OversizePolicy
+
+	redactKeys   []string
+	redactFuncs  []func(key string, val any) (any, bool)
+	hooks        []func(ctx context.Context, t time.Time, level, msg string, fields map[string]any)
+	filters      []func(ctx context.Context, level, msg string, fields map[string]any) bool
+	retainHook   bool // see RetainingHook
+	levelMetrics func(level string)
+	onError      func(err error)
+	marshalErr   func(err error) any
+	exit         func(code int)
+	extractors   []func(ctx context.Context) []Field
+}
+
+func New(w io.Writer, opts ...Option) *Log {
+	l := &Log{
+		mu:       new(sync.Mutex),
+		msgKey:   "msg",
+		levelKey: "level",
+		timeKey:  "time",
+		lineEnd:  "\n",
+		clock:    time.Now,
+		stacks:   &stackCache{m: make(map[stackKey]any)},
+	}
+	for _, opt := range opts {
+		opt.apply(l)
+	}
+	if l.bufPool == nil {
+		l.bufPool = &bufPool
+	}
+	if l.mapPool == nil {
+		l.mapPool = &mapPool
+	}
+	if l.entPool == nil {
+		l.entPool = &entryPool
+	}
+	l.outputs = append([]output{{w: w, format: l.format, enc: l.encoder}}, l.outputs...)
+	for i, o := range l.outputs {
+		if o.primary {
+			l.outputs[i].format = l.format
+			l.outputs[i].enc = l.encoder
+			o.format = l.format
+		}
+		if o.format == FormatConsole {
+			l.outputs[i].color = l.color
+			if !l.colorSet {
+				l.outputs[i].color = isTerminal(o.w)
+			}
+		}
+	}
+	if l.asyncSize > 0 {
+		l.async = newAsync(l, l.asyncSize, l.asyncDrop)
+	}
+	return l
 }
 
-func New(w io.Writer, fields ...Field) *Log {
-	return &Log{
-		fields: fields,
-		w:      w,
+// WithFields returns Log which writes to the same writers and adds fields to every message,
+// in addition to fields of l. Fields override fields of l with the same keys.
+// Options of l are shared with the returned Log.
+func (l *Log) WithFields(fields ...Field) *Log {
+	if l == nil {
+		return nil
 	}
+
+	n := *l
+	// l.fields is never modified in place, so l can be used concurrently with n.
+	n.fields = make([]Field, 0, len(l.fields)+len(fields))
+	for _, f := range l.fields {
+		if !hasKey(fields, f.key) {
+			n.fields = append(n.fields, f)
+		}
+	}
+	n.fields = append(n.fields, fields...)
+	return &n
+}
+
+// Nop returns Log which prints nothing, all its methods are safe to use.
+func Nop() *Log {
+	l := New(io.Discard)
+	l.nop = true
+	return l
 }
 
-// Print prints message msg with specified fields.
+// Named returns Log for a component, which writes to the same writers and adds "component" field to every message.
+// Names of nested Named calls are joined with '.', like "db.pool".
+// Options and fields of l are shared with the returned Log.
+func (l *Log) Named(name string) *Log {
+	if l == nil {
+		return nil
+	}
+
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+	n := l.WithFields(Str("component", name))
+	n.name = name
+	return n
+}
+
+// Print prints message msg with specified fields. Nil ctx is treated as context.Background().
 func (l *Log) Print(ctx context.Context, msg string, fields ...Field) {
+	l.output(ctx, 2, msg, fields)
+}
+
+// PrintErr is Print which returns error of writing the message, so callers can react to lost messages.
+// If the message can't be encoded, the line describing the encoding error is still written
+// and the encoding error is returned as well. With Async and Batch only encoding errors are returned,
+// write errors are reported by OnError and Batch.Commit.
+func (l *Log) PrintErr(ctx context.Context, msg string, fields ...Field) error {
+	return l.output(ctx, 2, msg, fields)
+}
+
+// Err prints message msg at error level with err as Error field and returns err,
+// so it can be used as return log.Err(ctx, err, "failed to save").
+// If err is nil, message is printed at info level without error field.
+// Level field in fields overrides the level.
+func (l *Log) Err(ctx context.Context, err error, msg string, fields ...Field) error {
+	l.err(ctx, err, msg, fields)
+	return err
+}
+
+func (l *Log) err(ctx context.Context, err error, msg string, fields []Field) {
 	if l == nil {
 		return
 	}
 
-	cd, _ := ctx.Value(ctxkey).(*ctxdata)
-	l.print(&ctxdata{prev: cd, fields: fields}, msg)
+	fs := make([]Field, len(fields), len(fields)+2)
+	copy(fs, fields)
+	if err != nil {
+		fs = append(fs, Level(LevelError), Error(err))
+	} else {
+		fs = append(fs, Level(LevelInfo))
+	}
+	l.output(ctx, 3, msg, fs)
+}
+
+// Fatal prints message msg at fatal level, waits for it to be written with Sync and calls os.Exit(1),
+// or the function set by ExitFunc.
+func (l *Log) Fatal(ctx context.Context, msg string, fields ...Field) {
+	l.fatal(ctx, msg, fields)
 }
 
-// Writer returns io.Writer which calls l.Print for every write to it.
-func (l *Log) Writer(ctx context.Context) io.Writer {
-	return &writer{
-		l:   l,
-		ctx: ctx,
+func (l *Log) fatal(ctx context.Context, msg string, fields []Field) {
+	fs := make([]Field, 0, len(fields)+1)
+	fs = append(fs, Level(LevelFatal))
+	fs = append(fs, fields...)
+	l.output(ctx, 3, msg, fs)
+	l.Sync()
+
+	if l != nil && l.exit != nil {
+		l.exit(1)
+		return
 	}
+	os.Exit(1)
 }
 
-type writer struct {
-	l   *Log
-	ctx context.Context
+// Panic prints message msg at error level, waits for it to be written with Sync and panics with msg,
+// for violated invariants which should be both logged and unwound with a stack trace.
+func (l *Log) Panic(ctx context.Context, msg string, fields ...Field) {
+	l.panic(ctx, msg, fields)
 }
 
-func (w *writer) Write(p []byte) (n int, err error) {
-	w.l.Print(w.ctx, string(bytes.TrimSpace(p)))
-	return len(p), nil
+func (l *Log) panic(ctx context.Context, msg string, fields []Field) {
+	fs := make([]Field, 0, len(fields)+1)
+	fs = append(fs, Level(LevelError))
+	fs = append(fs, fields...)
+	l.output(ctx, 3, msg, fs)
+	l.Sync()
+	panic(msg)
 }
 
-// Stacker can be implemented by errors to include stack trace info in logs.
-// Use runtime.Callers to get pc slice.
-type Stacker interface {
-	Stack() (pc []uintptr)
+// output prints message msg with specified fields.
+// calldepth is the number of stack frames to skip to get to the caller reported by WithCaller,
+// 1 means the caller of output.
+func (l *Log) output(ctx context.Context, calldepth int, msg string, fields []Field) error {
+	if l == nil {
+		return nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	cd, _ := ctx.Value(ctxkey).(*ctxdata)
+	return l.print(ctx, cd, fields, calldepth+1, msg)
 }
 
-func stack(v Stacker) []string {
-	frames := runtime.CallersFrames(v.Stack())
-	st := make([]string, 0, len(v.Stack()))
-	for {
-		frame, more := frames.Next()
-		st = append(st, fmt.Sprintf("%s:%d[%s]", frame.File, frame.Line, frame.Func.Name()))
+// Sync waits for messages queued by Async to be written
+// and flushes the underlying writers if they implement Sync() error or Flush() error,
+// like *os.File or *bufio.Writer. Messages written to buffered writers are lost on exit
+// unless Sync is called, so use defer log.Sync() in main.
+func (l *Log) Sync() error {
+	if l == nil {
+		return nil
+	}
+	if l.deduper != nil {
+		l.deduper.flush()
+	}
+	if l.async != nil {
+		l.async.flush()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
 
-		if !more {
-			return st
+	var errs []error
+	for _, o := range l.outputs {
+		if err := syncWriter(o.w); err != nil {
+			errs = append(errs, err)
 		}
 	}
+	return errors.Join(errs...)
 }
 
-type Field struct {
-	key string
-	val any
-}
-
-func Value(k string, v any) Field {
-	return Field{key: k, val: v}
+// Close writes messages queued by Async, stops the background goroutine and syncs the writers.
+// Messages printed after Close are written synchronously.
+// Writers are not closed.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	if l.async != nil {
+		l.async.close()
+	}
+	return l.Sync()
 }
 
-func Error(err error) Field {
-	return Field{key: "error", val: err}
+// Dropped returns number of messages dropped because the Async queue was full, see AsyncDrop.
+func (l *Log) Dropped() uint64 {
+	if l == nil || l.async == nil {
+		return 0
+	}
+	return l.async.dropped.Load()
 }
 
-func Time(t time.Time) Field {
-	return Field{key: "time", val: t}
+func syncWriter(w io.Writer) error {
+	switch w := w.(type) {
+	case interface{ Sync() error }:
+		return w.Sync()
+	case interface{ Flush() error }:
+		return w.Flush()
+	default:
+		return nil
+	}
 }
 
 type ctxkeytype struct{}
@@ -120,6 +564,54 @@ var ctxkey = ctxkeytype{}
 type ctxdata struct {
 	prev   *ctxdata
 	fields []Field
+	group  string
+}
+
+// flatten returns fields of cd and its predecessors with shadowed fields removed, see FromContext.
+func (cd *ctxdata) flatten() []Field {
+	var chain []*ctxdata
+	for d := cd; d != nil; d = d.prev {
+		chain = append(chain, d)
+	}
+
+	var (
+		groups []string
+		depths = make([]int, len(chain))
+	)
+	for i := len(chain) - 1; i >= 0; i-- {
+		if chain[i].group != "" {
+			groups = append(groups, chain[i].group)
+		}
+		depths[i] = len(groups)
+	}
+
+	nested := make([][]Field, len(groups)+1)
+	for i := len(chain) - 1; i >= 0; i-- {
+		for j, f := range chain[i].fields {
+			if f.key == "" || hasKey(chain[i].fields[:j], f.key) {
+				continue
+			}
+			shadowed := false
+			for k := 0; k < i && !shadowed; k++ {
+				shadowed = (depths[k] == depths[i] || isMetaKey(f.key)) && hasKey(chain[k].fields, f.key)
+			}
+			if shadowed {
+				continue
+			}
+			depth := depths[i]
+			if isMetaKey(f.key) {
+				depth = 0
+			}
+			nested[depth] = append(nested[depth], f)
+		}
+	}
+
+	for depth := len(groups); depth > 0; depth-- {
+		if len(nested[depth]) > 0 {
+			nested[depth-1] = append(nested[depth-1], Object(groups[depth-1], nested[depth]...))
+		}
+	}
+	return nested[0]
 }
 
 func MuWriter(w io.Writer) io.Writer {
@@ -136,3 +628,9 @@ func (w *muWriter) Write(p []byte) (n int, err error) {
 	defer w.mu.Unlock()
 	return w.w.Write(p)
 }
+
+func (w *muWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return syncWriter(w.w)
+}
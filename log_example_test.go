@@ -23,5 +23,13 @@ func ExampleLog_Print_error() {
 
 	ctx = ctxlog.With(ctx, ctxlog.Value("foo", "bar"), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
 	log.Print(ctx, "read error", ctxlog.Error(fmt.Errorf("broken pipe")))
-	// Output: {"error":"broken pipe","foo":"bar","msg":"read error","time":"2000-01-01T00:00:00Z"}
+	// Output: {"msg":"read error","foo":"bar","error":"broken pipe","time":"2000-01-01T00:00:00Z"}
+}
+
+func ExampleLogfmt() {
+	log := ctxlog.New(os.Stdout, ctxlog.Logfmt())
+	ctx := context.Background()
+
+	log.Print(ctx, "hello world", ctxlog.Str("foo", "bar baz"), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	// Output: msg="hello world" foo="bar baz" time=2000-01-01T00:00:00Z
 }
@@ -1,9 +1,20 @@
 package ctxlog_test
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -17,7 +28,7 @@ func TestPrintInfo(t *testing.T) {
 
 	log.Print(ctx, "foo", ctxlog.Value("foo", "baz"))
 
-	expected := `{"foo":"baz","msg":"foo","time":"2000-01-01T00:00:00Z"}` + "\n"
+	expected := `{"msg":"foo","foo":"baz","time":"2000-01-01T00:00:00Z"}` + "\n"
 	got := buf.String()
 	if expected != got {
 		t.Errorf("expected: %v, got: %v", expected, got)
@@ -31,7 +42,7 @@ func TestWithError(t *testing.T) {
 
 	log.Print(ctx, "foo")
 
-	expected := `{"error":"bar error","foo":"bar","msg":"foo","time":"2000-01-01T00:00:00Z"}` + "\n"
+	expected := `{"msg":"foo","foo":"bar","error":"bar error","time":"2000-01-01T00:00:00Z"}` + "\n"
 	got := buf.String()
 	if expected != got {
 		t.Errorf("expected: %v, got: %v", expected, got)
@@ -45,7 +56,7 @@ func TestEncoderError(t *testing.T) {
 
 	log.Print(ctx, "foo", ctxlog.Value("chan", make(chan struct{})))
 
-	expected := `{"error":"json: unsupported type: chan struct {}","msg":"ctxlog: json encode error","orig_msg":"foo","time":"2000-01-01T00:00:00Z"}` + "\n"
+	expected := `{"msg":"foo","foo":"bar","chan_error":"json: unsupported type: chan struct {}","time":"2000-01-01T00:00:00Z"}` + "\n"
 	got := buf.String()
 	if expected != got {
 		t.Errorf("expected: %v, got: %v", expected, got)
@@ -59,3 +70,2111 @@ func TestNilLog(t *testing.T) {
 	log.Print(ctx, "should not panic")
 	log.Writer(ctx).Write([]byte("should not panic either"))
 }
+
+func TestMinLevel(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.MinLevel(ctxlog.LevelWarn), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	log.Print(ctx, "dropped")
+	log.Print(ctx, "dropped", ctxlog.Level(ctxlog.LevelDebug))
+	log.Print(ctx, "foo", ctxlog.Level(ctxlog.LevelWarn))
+
+	expected := `{"msg":"foo","level":"warn","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestTypedFields(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := ctxlog.With(context.Background(), ctxlog.Int("int", 1), ctxlog.Value("any", 2))
+
+	log.Print(ctx, "foo",
+		ctxlog.Int64("int64", 3),
+		ctxlog.Float64("float64", 1.5),
+		ctxlog.Bool("bool", true),
+		ctxlog.Str("str", "bar"),
+		ctxlog.Dur("dur", 1500*time.Millisecond),
+	)
+
+	expected := `{"msg":"foo","int":1,"any":2,"int64":3,"float64":1.5,"bool":true,"str":"bar","dur":"1.5s","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestFieldOrder(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Value("a", "log"), ctxlog.Value("b", "log"), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := ctxlog.With(context.Background(), ctxlog.Value("c", "ctx"), ctxlog.Value("a", "ctx"))
+	ctx = ctxlog.With(ctx, ctxlog.Value("d", "ctx2"))
+
+	log.Print(ctx, "foo", ctxlog.Value("c", "call"), ctxlog.Value("c", "call2"), ctxlog.Level(ctxlog.LevelInfo))
+
+	expected := `{"msg":"foo","level":"info","b":"log","a":"ctx","d":"ctx2","c":"call","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestJSONEscape(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	log.Print(ctx, "<a&b>\"\n\x01\xff\u2028", ctxlog.Float64("small", 1e-7), ctxlog.Value("f32", float32(0.1)))
+
+	expected := `{"msg":"\u003ca\u0026b\u003e\"\n\u0001\ufffd\u2028","small":1e-7,"f32":0.1,"time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Logfmt(), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := ctxlog.With(context.Background(), ctxlog.Str("path", "/a=b"), ctxlog.Int("n", 1))
+
+	log.Print(ctx, "hello world", ctxlog.Str("q", `say "hi"`), ctxlog.Dur("dur", time.Second), ctxlog.Error(fmt.Errorf("broken pipe")))
+
+	expected := `msg="hello world" path="/a=b" n=1 q="say \"hi\"" dur=1s error="broken pipe" time=2000-01-01T00:00:00Z` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestConsole(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Printer(ctxlog.FormatConsole), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	log.Print(ctx, "hello world", ctxlog.Level(ctxlog.LevelWarn), ctxlog.Int("n", 1))
+	log.Print(ctx, "two\nlines \x1b[31mred", ctxlog.Level(ctxlog.LevelWarn))
+
+	expected := "2000-01-01 00:00:00.000 WARN  hello world n=1\n" +
+		`2000-01-01 00:00:00.000 WARN  "two\nlines \x1b[31mred"` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	buf.Reset()
+	log = ctxlog.New(buf, ctxlog.Printer(ctxlog.FormatConsole), ctxlog.ForceColor(true), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	log.Print(ctx, "hello world", ctxlog.Level(ctxlog.LevelError), ctxlog.Int("n", 1))
+
+	expected = "\x1b[90m2000-01-01 00:00:00.000\x1b[0m \x1b[31mERROR\x1b[0m \x1b[1mhello world\x1b[0m \x1b[36mn=\x1b[0m1\n"
+	got = buf.String()
+	if expected != got {
+		t.Errorf("expected: %q, got: %q", expected, got)
+	}
+}
+
+func TestWithCaller(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.WithCaller(), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	_, _, line, _ := runtime.Caller(0)
+	log.Print(ctx, "foo")
+	ctxlog.Global(log)
+	ctxlog.Print(ctx, "bar")
+	ctxlog.Global(nil)
+
+	expected := fmt.Sprintf(`{"msg":"foo","caller":"log_test.go:%d","time":"2000-01-01T00:00:00Z"}`+"\n", line+1) +
+		fmt.Sprintf(`{"msg":"bar","caller":"log_test.go:%d","time":"2000-01-01T00:00:00Z"}`+"\n", line+3)
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf,
+		ctxlog.Redact("password", "Token"),
+		ctxlog.RedactFunc(func(key string, val any) (any, bool) {
+			s, ok := val.(string)
+			if key != "card" || !ok || len(s) < 4 {
+				return nil, false
+			}
+			return "****" + s[len(s)-4:], true
+		}),
+		ctxlog.Str("token", "secret"),
+		ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)),
+	)
+	ctx := ctxlog.With(context.Background(), ctxlog.Str("PASSWORD", "secret"))
+
+	log.Print(ctx, "foo", ctxlog.Str("card", "1234567812345678"), ctxlog.Int("n", 1))
+	log.Print(ctxlog.WithGroup(context.Background(), "password"), "bar", ctxlog.Str("key", "secret"))
+	log.Print(context.Background(), "baz", ctxlog.Object("password", ctxlog.Str("key", "secret")),
+		ctxlog.Object("user", ctxlog.Str("password", "secret"), ctxlog.Str("name", "x")))
+
+	expected := `{"msg":"foo","token":"[REDACTED]","PASSWORD":"[REDACTED]","card":"****5678","n":1,"time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"bar","token":"[REDACTED]","password":"[REDACTED]","time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"baz","token":"[REDACTED]","password":"[REDACTED]","user":{"password":"[REDACTED]","name":"x"},"time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestSample(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Sample(3), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	for i := 0; i < 4; i++ {
+		log.Print(ctx, "foo")
+	}
+	log.Print(ctx, "bar")
+
+	expected := `{"msg":"foo","time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"foo","sampled_dropped":2,"time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"bar","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	// The least recently printed messages are forgotten.
+	log.Print(ctx, "foo")
+	for i := 0; i < 1024; i++ {
+		log.Print(ctx, fmt.Sprint("msg", i))
+	}
+	buf.Reset()
+	log.Print(ctx, "foo")
+
+	expected = `{"msg":"foo","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got = buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestSync(t *testing.T) {
+	buf := new(bytes.Buffer)
+	bw := bufio.NewWriter(buf)
+	log := ctxlog.New(bw, ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	log.Print(ctx, "foo")
+	if buf.Len() != 0 {
+		t.Fatalf("expected buffered output, got: %v", buf.String())
+	}
+	if err := log.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"msg":"foo","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ctx := ctxlog.With(context.Background(), ctxlog.Str("user_id", "ctx"), ctxlog.Str("dup", "first"), ctxlog.Str("dup", "last"))
+
+	log := ctxlog.New(buf, ctxlog.Str("user_id", "log"), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	log.Print(ctx, "foo")
+	log.Print(ctx, "foo", ctxlog.Str("user_id", "call"))
+
+	log = ctxlog.New(buf, ctxlog.LastWins(), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	log.Print(ctx, "foo", ctxlog.Str("user_id", "call"))
+
+	expected := `{"msg":"foo","user_id":"ctx","dup":"first","time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"foo","dup":"first","user_id":"call","time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"foo","dup":"last","user_id":"call","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+type cyclicError struct {
+	next error
+}
+
+func (e *cyclicError) Error() string { return "cyclic" }
+func (e *cyclicError) Unwrap() error { return e.next }
+
+func TestErrorChain(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	err := fmt.Errorf("read config: %w", fmt.Errorf("open file: %w", errors.New("permission denied")))
+	log.Print(ctx, "foo", ctxlog.Error(err))
+
+	expected := `{"msg":"foo","error":"read config: open file: permission denied","error_chain":["read config: open file: permission denied","open file: permission denied","permission denied"],"time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	cyclic := new(cyclicError)
+	cyclic.next = cyclic
+	log.Print(ctx, "should not hang", ctxlog.Error(cyclic))
+}
+
+type stackError struct {
+	msg string
+	pc  []uintptr
+	err error
+}
+
+func (e *stackError) Error() string    { return e.msg }
+func (e *stackError) Stack() []uintptr { return e.pc }
+func (e *stackError) Unwrap() error    { return e.err }
+
+func TestWrappedStacker(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	pc := make([]uintptr, 1)
+	runtime.Callers(1, pc)
+	frame, _ := runtime.CallersFrames(pc).Next()
+
+	outerPC := make([]uintptr, 1)
+	runtime.Callers(0, outerPC)
+
+	inner := &stackError{msg: "inner", pc: pc}
+	outer := &stackError{msg: "outer", pc: outerPC, err: fmt.Errorf("wrapped: %w", inner)}
+	log.Print(ctx, "foo", ctxlog.Error(errors.Join(errors.New("other"), outer)))
+
+	expected := fmt.Sprintf(`"error_stack":["%s:%d[%s]"]`, frame.File, frame.Line, frame.Function)
+	got := buf.String()
+	if !strings.Contains(got, expected) {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := ctxlog.With(context.Background(), ctxlog.Str("a", "1"), ctxlog.Str("b", "2"), ctxlog.Str("a", "x"))
+	ctx = ctxlog.With(ctx, ctxlog.Str("b", "3"))
+	ctx = ctxlog.WithGroup(ctx, "http")
+	ctx = ctxlog.With(ctx, ctxlog.Str("method", "GET"), ctxlog.Level(ctxlog.LevelWarn))
+
+	fields, ok := ctxlog.FromContext(ctx)
+	if !ok {
+		t.Fatal("expected fields in context")
+	}
+	log.Print(ctx, "foo")
+	log.Print(ctxlog.NewContext(context.Background(), fields), "foo")
+
+	lines := strings.SplitAfter(buf.String(), "\n")
+	expected := `{"msg":"foo","level":"warn","a":"1","b":"3","http":{"method":"GET"},"time":"2000-01-01T00:00:00Z"}` + "\n"
+	for _, got := range lines[:2] {
+		if got != expected {
+			t.Errorf("expected: %v, got: %v", expected, got)
+		}
+	}
+
+	if _, ok := ctxlog.FromContext(ctxlog.NewContext(ctx, nil)); ok {
+		t.Errorf("expected no fields after NewContext with nil fields")
+	}
+}
+
+func TestMergeContexts(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	dst := ctxlog.With(context.Background(), ctxlog.Str("service", "worker"), ctxlog.Str("id", "bg"))
+	dst = ctxlog.WithGroup(dst, "job")
+	dst = ctxlog.With(dst, ctxlog.Str("name", "sync"))
+	src := ctxlog.With(context.Background(), ctxlog.Str("id", "req"), ctxlog.Str("method", "GET"))
+
+	log.Print(ctxlog.MergeContexts(dst, src), "foo")
+	log.Print(ctxlog.MergeContexts(context.Background(), src), "foo")
+	log.Print(ctxlog.MergeContexts(dst, context.Background()), "foo")
+
+	// Groups with the same name are merged, open group of dst stays open.
+	src = ctxlog.With(ctxlog.WithGroup(src, "job"), ctxlog.Str("name", "req"), ctxlog.Int("try", 1))
+	dst = ctxlog.With(dst, ctxlog.Str("queue", "q"))
+	log.Print(ctxlog.With(ctxlog.MergeContexts(dst, src), ctxlog.Int("n", 1)), "foo")
+
+	expected := `{"msg":"foo","service":"worker","id":"req","method":"GET","job":{"name":"sync"},"time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"foo","id":"req","method":"GET","time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"foo","service":"worker","id":"bg","job":{"name":"sync"},"time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"foo","service":"worker","id":"req","method":"GET","job":{"queue":"q","name":"req","try":1,"n":1},"time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if got != expected {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestCachedStack(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	pc := make([]uintptr, 1)
+	runtime.Callers(1, pc)
+	frame, _ := runtime.CallersFrames(pc).Next()
+
+	err := &stackError{msg: "failed", pc: pc}
+	log.Print(ctx, "foo", ctxlog.Error(err))
+	log.Print(ctx, "foo", ctxlog.Error(err))
+
+	line := fmt.Sprintf(`{"msg":"foo","error":"failed","error_stack":["%s:%d[%s]"],"time":"2000-01-01T00:00:00Z"}`+"\n",
+		frame.File, frame.Line, frame.Function)
+	expected := line + line
+	got := buf.String()
+	if got != expected {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestWithGroup(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := ctxlog.With(context.Background(), ctxlog.Str("method", "top"))
+	ctx = ctxlog.WithGroup(ctx, "http")
+	ctx = ctxlog.With(ctx, ctxlog.Str("method", "GET"))
+	ctx = ctxlog.WithGroup(ctx, "resp")
+
+	log.Print(ctx, "foo", ctxlog.Int("status", 200), ctxlog.Level(ctxlog.LevelInfo))
+	log.Print(ctxlog.WithGroup(context.Background(), "empty"), "bar")
+
+	expected := `{"msg":"foo","level":"info","method":"top","http":{"method":"GET","resp":{"status":200}},"time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"bar","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	buf.Reset()
+	log = ctxlog.New(buf, ctxlog.Logfmt(), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	log.Print(ctx, "foo", ctxlog.Int("status", 200))
+
+	expected = `msg=foo method=top http.method=GET http.resp.status=200 time=2000-01-01T00:00:00Z` + "\n"
+	got = buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestHook(t *testing.T) {
+	buf := new(bytes.Buffer)
+	var levels []string
+	log := ctxlog.New(buf,
+		ctxlog.Hook(func(ctx context.Context, level, msg string, fields map[string]any) {
+			fields["trace_id"] = "abc"
+			http := fields["http"].(map[string]any)
+			delete(http, "secret")
+			http["status"] = 500
+			panic("should not prevent printing")
+		}),
+		ctxlog.Hook(func(ctx context.Context, level, msg string, fields map[string]any) {
+			levels = append(levels, level)
+		}),
+		ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)),
+	)
+	ctx := ctxlog.WithGroup(context.Background(), "http")
+
+	log.Print(ctx, "foo", ctxlog.Level(ctxlog.LevelWarn), ctxlog.Str("secret", "x"), ctxlog.Str("method", "GET"), ctxlog.Int("status", 200))
+
+	expected := `{"msg":"foo","level":"warn","http":{"method":"GET","status":500},"trace_id":"abc","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	if len(levels) != 1 || levels[0] != ctxlog.LevelWarn {
+		t.Errorf("expected: %v, got: %v", []string{ctxlog.LevelWarn}, levels)
+	}
+}
+
+func TestRetainingHook(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		retained []map[string]any
+	)
+	log := ctxlog.New(io.Discard, ctxlog.RetainingHook(func(ctx context.Context, level, msg string, fields map[string]any) {
+		mu.Lock()
+		retained = append(retained, fields)
+		mu.Unlock()
+	}))
+	ctx := context.Background()
+
+	// Retained maps are read while other messages are printed, run with -race.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			mu.Lock()
+			for _, m := range retained {
+				_ = m["n"]
+			}
+			mu.Unlock()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				log.Print(ctx, "foo", ctxlog.Int("n", i*10+j))
+			}
+		}(i)
+	}
+	wg.Wait()
+	<-done
+
+	seen := make(map[any]bool)
+	for _, m := range retained {
+		seen[m["n"]] = true
+	}
+	expected := 100
+	got := len(seen)
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+type spanKey struct{}
+
+func TestWithTraceContext(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf,
+		ctxlog.WithTraceContext(func(ctx context.Context) (string, string, bool) {
+			span, ok := ctx.Value(spanKey{}).([2]string)
+			return span[0], span[1], ok
+		}),
+		ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)),
+	)
+	ctx := context.Background()
+
+	log.Print(ctx, "no span")
+	log.Print(context.WithValue(ctx, spanKey{}, [2]string{"t1", "s1"}), "span")
+
+	expected := `{"msg":"no span","time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"span","trace_id":"t1","span_id":"s1","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+type tenantKey struct{}
+
+func TestContextExtractor(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf,
+		ctxlog.Str("tenant", "default"),
+		ctxlog.ContextExtractor(func(ctx context.Context) []ctxlog.Field {
+			tenant, ok := ctx.Value(tenantKey{}).(string)
+			if !ok {
+				return nil
+			}
+			return []ctxlog.Field{ctxlog.Str("tenant", tenant), ctxlog.Str("source", "first")}
+		}),
+		ctxlog.ContextExtractor(func(ctx context.Context) []ctxlog.Field {
+			return []ctxlog.Field{ctxlog.Str("source", "second")}
+		}),
+		ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)),
+	)
+	ctx := context.WithValue(context.Background(), tenantKey{}, "acme")
+
+	log.Print(ctx, "foo")
+	log.Print(ctxlog.With(ctx, ctxlog.Str("tenant", "override")), "foo")
+
+	expected := `{"msg":"foo","tenant":"acme","source":"second","time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"foo","source":"second","tenant":"override","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestTimeKeyFormat(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ctx := context.Background()
+
+	log := ctxlog.New(buf, ctxlog.TimeKey("ts"), ctxlog.TimeFormat(ctxlog.TimeFormatUnixMilli), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 1, 0, time.UTC)))
+	log.Print(ctx, "foo")
+	log.Print(ctx, "foo", ctxlog.Value("chan", make(chan struct{})))
+
+	log = ctxlog.New(buf, ctxlog.TimeFormat(time.DateOnly), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 1, 0, time.UTC)))
+	log.Print(ctx, "foo")
+
+	expected := `{"msg":"foo","ts":946684801000}` + "\n" +
+		`{"msg":"foo","chan_error":"json: unsupported type: chan struct {}","ts":946684801000}` + "\n" +
+		`{"msg":"foo","time":"2000-01-01"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestNoTime(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.NoTime())
+	ctx := context.Background()
+
+	log.Print(ctx, "foo")
+	log.Print(ctx, "foo", ctxlog.Value("chan", make(chan struct{})))
+	log.Print(ctx, "foo", ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	expected := `{"msg":"foo"}` + "\n" +
+		`{"msg":"foo","chan_error":"json: unsupported type: chan struct {}"}` + "\n" +
+		`{"msg":"foo","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestEncoderErrorInvalidTime(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf)
+	ctx := context.Background()
+
+	log.Print(ctx, "foo", ctxlog.Value("time", "yesterday"), ctxlog.Value("chan", make(chan struct{})))
+
+	var got struct {
+		Msg  string    `json:"msg"`
+		Time time.Time `json:"time"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid json %q: %v", buf.String(), err)
+	}
+	if got.Msg != "foo" || time.Since(got.Time) > time.Minute {
+		t.Errorf("expected message with current time, got: %v", buf.String())
+	}
+}
+
+func TestEncoderErrorLogfmt(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Logfmt(), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	log.Print(ctx, "foo", ctxlog.Value("chan", make(chan struct{})), ctxlog.Int("n", 1))
+
+	expected := `msg=foo chan_error="json: unsupported type: chan struct {}" n=1 time=2000-01-01T00:00:00Z` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestAddOutput(t *testing.T) {
+	buf := new(bytes.Buffer)
+	buf2 := new(bytes.Buffer)
+	buf3 := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.AddOutput(buf2, ctxlog.FormatLogfmt), ctxlog.AddOutput(buf3, ctxlog.FormatJSON), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	log.Print(ctx, "hello", ctxlog.Int("n", 1))
+
+	expected := `{"msg":"hello","n":1,"time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	got = buf3.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	expected = "msg=hello n=1 time=2000-01-01T00:00:00Z\n"
+	got = buf2.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestLevelOutput(t *testing.T) {
+	buf := new(bytes.Buffer)
+	errBuf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.LevelOutput(ctxlog.LevelError, errBuf), ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	log.Print(ctx, "started")
+	log.Print(ctx, "failed", ctxlog.Level(ctxlog.LevelError))
+
+	expected := "msg=started\nmsg=failed level=error\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	expected = "msg=failed level=error\n"
+	got = errBuf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestAsync(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Async(10), ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	log.Print(ctx, "foo", ctxlog.Int("n", 1))
+	log.Print(ctx, "bar", ctxlog.Int("n", 2))
+	if err := log.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "msg=foo n=1\nmsg=bar n=2\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+	log.Print(ctx, "baz")
+
+	expected += "msg=baz\n"
+	got = buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+// blockingWriter blocks every Write until release is closed, started is closed on the first Write.
+type blockingWriter struct {
+	buf     bytes.Buffer
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	w.once.Do(func() { close(w.started) })
+	<-w.release
+	return w.buf.Write(p)
+}
+
+func TestAsyncDrop(t *testing.T) {
+	w := &blockingWriter{started: make(chan struct{}), release: make(chan struct{})}
+	log := ctxlog.New(w, ctxlog.Async(1), ctxlog.AsyncDrop(), ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	log.Print(ctx, "1")
+	<-w.started
+	log.Print(ctx, "2")
+	log.Print(ctx, "3")
+	log.Print(ctx, "4")
+	close(w.release)
+	if err := log.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "msg=1\nmsg=2\n"
+	got := w.buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	if got := log.Dropped(); got != 2 {
+		t.Errorf("expected: %v, got: %v", 2, got)
+	}
+}
+
+type user struct {
+	ID       int
+	Password string
+}
+
+func (u user) LogValue() any {
+	return map[string]any{"id": u.ID}
+}
+
+type loopValuer struct{}
+
+func (v loopValuer) LogValue() any {
+	return v
+}
+
+func TestLogValuer(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	log.Print(ctx, "foo", ctxlog.Value("user", user{ID: 1, Password: "secret"}), ctxlog.Value("loop", loopValuer{}))
+
+	expected := `{"msg":"foo","user":{"id":1},"loop":{},"time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestMergeKeys(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.MergeKeys("err", "tag"), ctxlog.Str("tag", "api"), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := ctxlog.With(context.Background(), ctxlog.Value("err", errors.New("timeout")), ctxlog.Str("user", "a"))
+	ctx = ctxlog.With(ctx, ctxlog.Str("user", "b"))
+
+	log.Print(ctx, "foo", ctxlog.Value("err", errors.New("retry failed")), ctxlog.Str("tag", "v2"))
+
+	expected := `{"msg":"foo","tag":["api","v2"],"err":["timeout","retry failed"],"user":"b","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestReservedKeyPolicy(t *testing.T) {
+	tests := []struct {
+		policy   ctxlog.KeyPolicy
+		expected string
+	}{
+		{ctxlog.KeyPolicyIgnore, `{"msg":"foo","level":"warn","error":"broken: wrapped","error_chain":["custom"],"ts":"2000-01-01T00:00:00Z"}` + "\n"},
+		{ctxlog.KeyPolicyRename, `{"msg":"foo","level":"warn","fields.msg":"bar","fields.ts":"now","error":"broken: wrapped","error_chain":["broken: wrapped","wrapped"],"fields.error_chain":["custom"],"ts":"2000-01-01T00:00:00Z"}` + "\n"},
+		{ctxlog.KeyPolicyPrefix, `{"ctxlog.msg":"foo","level":"warn","msg":"bar","ts":"now","error":"broken: wrapped","ctxlog.error_chain":["broken: wrapped","wrapped"],"error_chain":["custom"],"ctxlog.ts":"2000-01-01T00:00:00Z"}` + "\n"},
+	}
+	for _, tt := range tests {
+		buf := new(bytes.Buffer)
+		log := ctxlog.New(buf, ctxlog.ReservedKeyPolicy(tt.policy), ctxlog.TimeKey("ts"), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+		ctx := ctxlog.With(context.Background(), ctxlog.Level(ctxlog.LevelWarn), ctxlog.Str("msg", "bar"), ctxlog.Str("ts", "now"))
+
+		err := fmt.Errorf("broken: %w", errors.New("wrapped"))
+		log.Print(ctx, "foo", ctxlog.Error(err), ctxlog.Value("error_chain", []string{"custom"}))
+
+		got := buf.String()
+		if tt.expected != got {
+			t.Errorf("expected: %v, got: %v", tt.expected, got)
+		}
+	}
+}
+
+func TestWithContextState(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.WithContextState(), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	log.Print(context.Background(), "foo")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	log.Print(ctx, "bar")
+
+	expected := `{"msg":"foo","time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"bar","ctx_err":"context canceled","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	buf.Reset()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	log.Print(ctx, "baz")
+
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	d, err := time.ParseDuration(fmt.Sprint(m["ctx_deadline"]))
+	if err != nil || d <= 0 || d > time.Hour {
+		t.Errorf("expected: %v, got: %v", "ctx_deadline up to 1h", m["ctx_deadline"])
+	}
+}
+
+func TestNamed(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Logfmt(), ctxlog.Str("app", "api"), ctxlog.NoTime())
+	ctx := context.Background()
+
+	db := log.Named("db")
+	db.Print(ctx, "foo")
+	db.Named("pool").Print(ctx, "bar")
+	log.Print(ctx, "baz")
+
+	expected := "msg=foo app=api component=db\nmsg=bar app=api component=db.pool\nmsg=baz app=api\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestWithFields(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Logfmt(), ctxlog.Str("app", "api"), ctxlog.Str("env", "dev"), ctxlog.NoTime())
+	ctx := context.Background()
+
+	child := log.WithFields(ctxlog.Str("env", "prod"), ctxlog.Int("shard", 1))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		child.WithFields(ctxlog.Int("n", 1))
+		log.WithFields(ctxlog.Int("n", 2))
+	}()
+	child.Print(ctx, "foo")
+	wg.Wait()
+	log.Print(ctx, "bar")
+
+	expected := "msg=foo app=api env=prod shard=1\nmsg=bar app=api env=dev\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestNilContext(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Logfmt(), ctxlog.NoTime())
+	var ctx context.Context
+
+	log.Print(ctx, "foo")
+	log.Print(ctxlog.With(ctx, ctxlog.Int("n", 1)), "bar")
+
+	expected := "msg=foo\nmsg=bar n=1\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	for _, ctx := range []context.Context{ctxlog.With(ctx), ctxlog.WithGroup(ctx, ""), ctxlog.WithKV(ctx)} {
+		if ctx != context.Background() {
+			t.Errorf("expected: %v, got: %v", context.Background(), ctx)
+		}
+	}
+}
+
+func TestFields(t *testing.T) {
+	ctx := ctxlog.With(context.Background(), ctxlog.Str("user", "a"), ctxlog.Level(ctxlog.LevelWarn))
+	ctx = ctxlog.WithGroup(ctx, "http")
+	ctx = ctxlog.With(ctx, ctxlog.Str("method", "GET"))
+	ctx = ctxlog.With(ctx, ctxlog.Str("method", "POST"))
+
+	expected := `map[http:map[method:POST] level:warn user:a]`
+	got := fmt.Sprint(ctxlog.Fields(ctx))
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	expected = `map[]`
+	got = fmt.Sprint(ctxlog.Fields(context.Background()))
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Dedup(50*time.Millisecond), ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		log.Print(ctx, "query failed", ctxlog.Error(errors.New("connection refused")))
+	}
+	log.Print(ctx, "query failed", ctxlog.Error(errors.New("timeout")))
+	time.Sleep(60 * time.Millisecond)
+	log.Print(ctx, "query failed", ctxlog.Error(errors.New("connection refused")))
+
+	expected := `msg="query failed" error="connection refused"` + "\n" +
+		`msg="query failed" error="connection refused" repeated=2` + "\n" +
+		`msg="query failed" error=timeout` + "\n" +
+		`msg="query failed" error="connection refused"` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+// safeBuffer is bytes.Buffer safe for concurrent use.
+type safeBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *safeBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *safeBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestDedupFlush(t *testing.T) {
+	buf := new(safeBuffer)
+	log := ctxlog.New(buf, ctxlog.Dedup(50*time.Millisecond), ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := ctxlog.With(context.Background(), ctxlog.Str("db", "main"))
+
+	// Suppressed lines are reported when the window closes.
+	for i := 0; i < 3; i++ {
+		log.Print(ctx, "query failed", ctxlog.Int("attempt", i))
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// And on Sync.
+	log.Print(ctx, "retry")
+	log.Print(ctx, "retry")
+	log.Sync()
+
+	expected := `msg="query failed" db=main attempt=0` + "\n" +
+		`msg="query failed" db=main attempt=1 repeated=2` + "\n" +
+		"msg=retry db=main\n" +
+		"msg=retry db=main repeated=1\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestDedupErrorMarshaler(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Dedup(time.Hour), ctxlog.Logfmt(), ctxlog.NoTime(),
+		ctxlog.ErrorMarshaler(func(err error) any { return []string{err.Error()} }))
+	ctx := context.Background()
+
+	log.Print(ctx, "query failed", ctxlog.Error(errors.New("connection refused")))
+	log.Print(ctx, "query failed", ctxlog.Error(errors.New("timeout")))
+	log.Print(ctx, "query failed", ctxlog.Error(errors.New("timeout")))
+
+	expected := `msg="query failed" error="[\"connection refused\"]"` + "\n" +
+		`msg="query failed" error="[\"timeout\"]"` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestErr(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.WithCaller(), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+	errSave := errors.New("disk full")
+
+	_, _, line, _ := runtime.Caller(0)
+	err := log.Err(ctx, errSave, "failed to save", ctxlog.Int("n", 1))
+	log.Err(ctx, nil, "saved")
+	ctxlog.Global(log)
+	ctxlog.Err(ctx, errSave, "failed again", ctxlog.Level(ctxlog.LevelWarn))
+	ctxlog.Global(nil)
+
+	if err != errSave {
+		t.Errorf("expected: %v, got: %v", errSave, err)
+	}
+	expected := fmt.Sprintf(`{"msg":"failed to save","level":"error","n":1,"error":"disk full","caller":"log_test.go:%d","time":"2000-01-01T00:00:00Z"}`+"\n", line+1) +
+		fmt.Sprintf(`{"msg":"saved","level":"info","caller":"log_test.go:%d","time":"2000-01-01T00:00:00Z"}`+"\n", line+2) +
+		fmt.Sprintf(`{"msg":"failed again","level":"warn","error":"disk full","caller":"log_test.go:%d","time":"2000-01-01T00:00:00Z"}`+"\n", line+4)
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestStackFormat(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.MaxStackDepth(1), ctxlog.StructuredStack(), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	// Stack starting inside runtime.Callers, its frame is trimmed.
+	pc := make([]uintptr, 10)
+	pc = pc[:runtime.Callers(0, pc)]
+	_, file, line, _ := runtime.Caller(0)
+	log.Print(ctx, "foo", ctxlog.Error(&stackError{msg: "failed", pc: pc}))
+
+	expected := fmt.Sprintf(`"error_stack":[{"file":"%s","func":"github.com/kaey/ctxlog_test.TestStackFormat","line":%d}]`, file, line-1)
+	got := buf.String()
+	if !strings.Contains(got, expected) {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestStackOnError(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.StackOnError(), ctxlog.MaxStackDepth(1), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	_, file, line, _ := runtime.Caller(0)
+	log.Print(ctx, "foo", ctxlog.Level(ctxlog.LevelError), ctxlog.Error(errors.New("failed")))
+	log.Print(ctx, "bar", ctxlog.Level(ctxlog.LevelWarn))
+
+	expected := fmt.Sprintf(`{"msg":"foo","level":"error","error":"failed","error_stack":["%s:%d[github.com/kaey/ctxlog_test.TestStackOnError]"],"time":"2000-01-01T00:00:00Z"}`+"\n", file, line+1) +
+		`{"msg":"bar","level":"warn","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestNop(t *testing.T) {
+	log := ctxlog.Nop()
+	ctx := context.Background()
+
+	log.Named("db").WithFields(ctxlog.Int("n", 1)).Print(ctx, "foo")
+	log.Err(ctx, errors.New("failed"), "bar")
+	fmt.Fprintln(log.Writer(ctx), "baz")
+	if err := log.Close(); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+}
+
+func TestWithLevel(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.MinLevel(ctxlog.LevelInfo), ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := context.Background()
+	debugCtx := ctxlog.WithLevel(ctx, ctxlog.LevelDebug)
+	quietCtx := ctxlog.WithLevel(ctx, ctxlog.LevelError)
+
+	log.Print(ctx, "foo", ctxlog.Level(ctxlog.LevelDebug))
+	log.Print(debugCtx, "bar", ctxlog.Level(ctxlog.LevelDebug))
+	log.Print(quietCtx, "baz", ctxlog.Level(ctxlog.LevelWarn))
+
+	expected := "msg=bar level=debug\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestElapsed(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.WithElapsed(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	log.Print(ctx, "foo", ctxlog.Elapsed(ctx))
+	expected := `{"msg":"foo"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	buf.Reset()
+	ctx = ctxlog.StartTimer(ctx)
+	time.Sleep(10 * time.Millisecond)
+	log.Print(ctx, "bar")
+
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if ms, _ := m["elapsed_ms"].(float64); ms < 10 {
+		t.Errorf("expected: %v, got: %v", ">= 10", m["elapsed_ms"])
+	}
+}
+
+// countWriter counts Write calls.
+type countWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestBatch(t *testing.T) {
+	w := new(countWriter)
+	log := ctxlog.New(w, ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := ctxlog.With(context.Background(), ctxlog.Int("n", 1))
+
+	b := log.Batch(ctx)
+	b.Print("foo")
+	log.Print(ctx, "bar")
+	b.Print("baz", ctxlog.Int("n", 2))
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	b.Print("qux")
+
+	expected := "msg=bar n=1\nmsg=foo n=1\nmsg=baz n=2\nmsg=qux n=1\n"
+	got := w.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	if w.writes != 3 {
+		t.Errorf("expected: %v, got: %v", 3, w.writes)
+	}
+}
+
+// blockWriter blocks writes until release is closed.
+type blockWriter struct {
+	countWriter
+	release chan struct{}
+}
+
+func (w *blockWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return w.countWriter.Write(p)
+}
+
+func TestBatchAsync(t *testing.T) {
+	w := &blockWriter{release: make(chan struct{})}
+	log := ctxlog.New(w, ctxlog.Logfmt(), ctxlog.NoTime(), ctxlog.Async(10))
+	ctx := context.Background()
+
+	log.Print(ctx, "first")
+	b := log.Batch(ctx)
+	b.Print("foo")
+	b.Print("bar")
+
+	// Commit queues the batch and doesn't wait for the blocked writer.
+	done := make(chan error)
+	go func() { done <- b.Commit() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Commit blocked on the writer")
+	}
+	close(w.release)
+	log.Close()
+
+	expected := "msg=first\nmsg=foo\nmsg=bar\n"
+	got := w.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	if w.writes > 2 {
+		t.Errorf("expected: %v, got: %v", "at most 2 writes", w.writes)
+	}
+}
+
+type failEncoder struct{}
+
+func (failEncoder) Encode(w io.Writer, fields []ctxlog.Field, msg string) error {
+	fmt.Fprint(w, "partial")
+	return errors.New("not supported")
+}
+
+func TestEncoder(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.WithEncoder(ctxlog.JSONEncoder{}), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := ctxlog.WithGroup(context.Background(), "http")
+
+	log.Print(ctx, "foo", ctxlog.Level(ctxlog.LevelWarn), ctxlog.Str("method", "GET"), ctxlog.Error(errors.New("failed")))
+
+	log = ctxlog.New(buf, ctxlog.WithEncoder(ctxlog.JSONEncoder{}), ctxlog.MsgKey("message"), ctxlog.OmitEmptyMsg(), ctxlog.NoTime())
+	log.Print(ctx, "bar")
+	log.Print(ctx, "", ctxlog.Int("n", 1))
+
+	expected := `{"msg":"foo","level":"warn","error":"failed","http":{"method":"GET"},"time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"message":"bar"}` + "\n" + `{"http":{"n":1}}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	buf.Reset()
+	log = ctxlog.New(buf, ctxlog.WithEncoder(failEncoder{}), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	log.Print(ctx, "foo")
+
+	expected = `{"msg":"ctxlog: json encode error","error":"not supported","orig_msg":"foo","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got = buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestSortKeys(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.SortKeys(true), ctxlog.Str("z", "1"), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := ctxlog.WithGroup(context.Background(), "http")
+
+	log.Print(ctx, "foo", ctxlog.Str("path", "/"), ctxlog.Str("method", "GET"), ctxlog.Level(ctxlog.LevelInfo), ctxlog.Error(errors.New("failed")))
+
+	expected := `{"msg":"foo","level":"info","error":"failed","http":{"method":"GET","path":"/"},"z":"1","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestSortAllKeys(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.SortAllKeys(true), ctxlog.Str("z", "1"), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := ctxlog.WithGroup(context.Background(), "http")
+
+	log.Print(ctx, "foo", ctxlog.Str("path", "/"), ctxlog.Level(ctxlog.LevelInfo), ctxlog.Error(errors.New("failed")))
+	log = ctxlog.New(buf, ctxlog.SortAllKeys(true), ctxlog.Logfmt(), ctxlog.OmitEmptyMsg(), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	log.Print(context.Background(), "", ctxlog.Str("a", "1"), ctxlog.Str("x", "2"))
+
+	expected := `{"error":"failed","http":{"path":"/"},"level":"info","msg":"foo","time":"2000-01-01T00:00:00Z","z":"1"}` + "\n" +
+		`a=1 time=2000-01-01T00:00:00Z x=2` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestDefaultFields(t *testing.T) {
+	buf := new(bytes.Buffer)
+	fs := []ctxlog.Field{ctxlog.Str("app", "foo"), ctxlog.Int("v", 1)}
+	log := ctxlog.New(buf, ctxlog.DefaultFields(fs...), ctxlog.NoTime())
+
+	log.Print(context.Background(), "bar")
+
+	expected := `{"msg":"bar","app":"foo","v":1}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestMsgKey(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.MsgKey("message"), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	log.Print(ctx, "foo")
+	log = ctxlog.New(buf, ctxlog.MsgKey("message"), ctxlog.Logfmt(), ctxlog.NoTime())
+	log.Print(ctx, "bar")
+
+	expected := `{"message":"foo","time":"2000-01-01T00:00:00Z"}` + "\n" + "message=bar\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestOmitEmptyMsg(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.OmitEmptyMsg(), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	log.Print(ctx, "", ctxlog.Str("foo", "bar"))
+	log.Print(ctx, "foo")
+	ctxlog.New(buf, ctxlog.OmitEmptyMsg(), ctxlog.NoTime()).Print(ctx, "")
+	log = ctxlog.New(buf, ctxlog.OmitEmptyMsg(), ctxlog.Logfmt(), ctxlog.NoTime())
+	log.Print(ctx, "", ctxlog.Level(ctxlog.LevelWarn))
+	log.Print(ctx, "bar")
+
+	expected := `{"foo":"bar","time":"2000-01-01T00:00:00Z"}` + "\n" + `{"msg":"foo","time":"2000-01-01T00:00:00Z"}` + "\n" +
+		"{}\n" + "level=warn\n" + "msg=bar\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestSortedMapKeys(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ctx := context.Background()
+	m := map[string]any{
+		"z": 1,
+		"b": map[string]any{"y": true, "a": "x", "m": []any{map[string]int{"d": 4, "c": 3}}},
+		"a": nil,
+	}
+
+	opts := [][]ctxlog.Option{
+		{},
+		{ctxlog.Logfmt()},
+		{ctxlog.Printer(ctxlog.FormatConsole)},
+		{ctxlog.WithEncoder(ctxlog.JSONEncoder{})},
+		{ctxlog.Hook(func(ctx context.Context, level, msg string, fields map[string]any) {})},
+	}
+	for _, o := range opts {
+		ctxlog.New(buf, append(o, ctxlog.NoTime())...).Print(ctx, "foo", ctxlog.Value("m", m))
+	}
+
+	sorted := `{"a":null,"b":{"a":"x","m":[{"c":3,"d":4}],"y":true},"z":1}`
+	expected := `{"msg":"foo","m":` + sorted + `}` + "\n" +
+		`msg=foo m=` + strconv.Quote(sorted) + "\n" +
+		`foo m=` + strconv.Quote(sorted) + "\n" +
+		`{"msg":"foo","m":` + sorted + `}` + "\n" +
+		`{"msg":"foo","m":` + sorted + `}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+type jsonUser struct {
+	name string
+}
+
+func (u jsonUser) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]string{"user": u.name})
+}
+
+func (u jsonUser) String() string { return "user " + u.name }
+
+type jsonID int
+
+func (id jsonID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fmt.Sprintf("id-%d", int(id)))
+}
+
+type textColor int
+
+func (c textColor) MarshalText() ([]byte, error) {
+	return []byte([]string{"red", "green"}[c]), nil
+}
+
+func TestMarshalers(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ctx := context.Background()
+
+	opts := [][]ctxlog.Option{
+		{},
+		{ctxlog.Logfmt()},
+		{ctxlog.Printer(ctxlog.FormatConsole)},
+		{ctxlog.WithEncoder(ctxlog.JSONEncoder{})},
+	}
+	for _, o := range opts {
+		ctxlog.New(buf, append(o, ctxlog.NoTime())...).Print(ctx, "foo",
+			ctxlog.Value("user", jsonUser{"bob"}), ctxlog.Value("id", jsonID(7)), ctxlog.Value("color", textColor(1)),
+			ctxlog.Array("ids", jsonID(1), jsonID(2)))
+	}
+
+	expected := `{"msg":"foo","user":{"user":"bob"},"id":"id-7","color":"green","ids":["id-1","id-2"]}` + "\n" +
+		`msg=foo user="{\"user\":\"bob\"}" id=id-7 color=green ids="[\"id-1\",\"id-2\"]"` + "\n" +
+		`foo user="{\"user\":\"bob\"}" id=id-7 color=green ids="[\"id-1\",\"id-2\"]"` + "\n" +
+		`{"msg":"foo","user":{"user":"bob"},"id":"id-7","color":"green","ids":["id-1","id-2"]}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+type panicStringer struct{}
+
+func (panicStringer) String() string { panic("boom") }
+
+type panicMarshaler struct{}
+
+func (panicMarshaler) MarshalJSON() ([]byte, error) { panic("boom") }
+
+func TestLogfmtNilPointer(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ctx := context.Background()
+
+	for _, o := range []ctxlog.Option{ctxlog.Printer(ctxlog.FormatJSON), ctxlog.Logfmt()} {
+		ctxlog.New(buf, o, ctxlog.NoTime()).Print(ctx, "foo",
+			ctxlog.Value("color", (*textColor)(nil)), ctxlog.Value("user", (*jsonUser)(nil)), ctxlog.Value("err", (*stackError)(nil)))
+	}
+	ctxlog.New(buf, ctxlog.Logfmt(), ctxlog.NoTime()).Print(ctx, "foo", ctxlog.Value("s", panicStringer{}), ctxlog.Int("n", 1))
+	ctxlog.New(buf, ctxlog.NoTime()).Print(ctx, "foo", ctxlog.Value("m", panicMarshaler{}), ctxlog.Int("n", 1))
+
+	expected := `{"msg":"foo","color":null,"user":null,"err":null}` + "\n" +
+		"msg=foo color=null user=null err=null\n" +
+		`msg=foo s_error="!PANIC: boom" n=1` + "\n" +
+		`{"msg":"foo","m_error":"!PANIC: boom","n":1}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestGlobalUnset(t *testing.T) {
+	ctx := context.Background()
+	ctxlog.Global(nil)
+
+	ctxlog.Print(ctx, "foo")
+	ctxlog.Err(ctx, errors.New("failed"), "bar")
+	if err := ctxlog.Sync(); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+	w := ctxlog.Writer(ctx)
+	fmt.Fprintln(w, "baz")
+
+	buf := new(bytes.Buffer)
+	ctxlog.Global(ctxlog.New(buf, ctxlog.Logfmt(), ctxlog.NoTime()))
+	defer ctxlog.Global(nil)
+	fmt.Fprintln(w, "qux")
+
+	expected := "msg=qux level=info\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestGlobalConcurrent(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(ctxlog.MuWriter(buf), ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := context.Background()
+	defer ctxlog.Global(nil)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ctxlog.Global(log)
+	}()
+	ctxlog.Global(nil)
+	ctxlog.Print(ctx, "foo")
+	wg.Wait()
+	ctxlog.Print(ctx, "bar")
+
+	if got := buf.String(); !strings.HasSuffix(got, "msg=bar\n") {
+		t.Errorf("expected: %v, got: %v", "msg=bar", got)
+	}
+}
+
+func TestWithKV(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.LastWins(), ctxlog.NoTime())
+	ctx := ctxlog.WithKV(context.Background(), "a", 1, "b", "x", ctxlog.Bool("c", true), 42, "d")
+
+	log.Print(ctx, "foo")
+
+	expected := `{"msg":"foo","a":1,"b":"x","c":true,"BADKEY":"d"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.MaxStackDepth(1), ctxlog.WithCaller(), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	var line int
+	func() {
+		defer log.RecoverSwallow(ctx)
+		_, _, line, _ = runtime.Caller(0)
+		panic("boom")
+	}()
+
+	expected := fmt.Sprintf(`"panic":"boom","error_stack":["%s:%d[github.com/kaey/ctxlog_test.TestRecover.func1]"],"caller":"log_test.go:%d"`, callerFile(), line+1, line+1)
+	got := buf.String()
+	if !strings.Contains(got, expected) || !strings.HasPrefix(got, `{"msg":"panic","level":"error"`) {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	defer func() {
+		if r := recover(); r != "again" {
+			t.Errorf("expected: %v, got: %v", "again", r)
+		}
+	}()
+	defer log.Recover(ctx)
+	panic("again")
+}
+
+func callerFile() string {
+	_, file, _, _ := runtime.Caller(1)
+	return file
+}
+
+func TestLevelMetrics(t *testing.T) {
+	counts := make(map[string]int)
+	metrics := ctxlog.LevelMetrics(func(level string) {
+		counts[level]++
+	})
+	log := ctxlog.New(io.Discard, ctxlog.MinLevel(ctxlog.LevelInfo), metrics)
+	ctx := context.Background()
+
+	log.Print(ctx, "foo")
+	log.Print(ctx, "foo", ctxlog.Level(ctxlog.LevelDebug))
+	log.Print(ctx, "foo", ctxlog.Level(ctxlog.LevelWarn))
+	ctxlog.New(io.Discard, ctxlog.WithEncoder(failEncoder{}), metrics).Print(ctx, "foo")
+
+	expected := map[string]int{"info": 1, "warn": 1, "error": 1}
+	if !reflect.DeepEqual(expected, counts) {
+		t.Errorf("expected: %v, got: %v", expected, counts)
+	}
+}
+
+func TestClock(t *testing.T) {
+	buf := new(bytes.Buffer)
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.FixedZone("", 3600))
+	log := ctxlog.New(buf, ctxlog.Clock(func() time.Time { return now }))
+	ctx := context.Background()
+
+	log.Print(ctx, "foo")
+	log.Print(ctx, "bar", ctxlog.Time(time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)))
+
+	expected := `{"msg":"foo","time":"1999-12-31T23:00:00Z"}` + "\n" + `{"msg":"bar","time":"2001-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestWithGoroutineID(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.WithGoroutineID(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	done := make(chan struct{})
+	log.Print(ctx, "foo")
+	go func() {
+		log.Print(ctx, "bar")
+		close(done)
+	}()
+	<-done
+
+	var ids []int64
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var m struct{ Goid int64 }
+		if err := json.Unmarshal([]byte(line), &m); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, m.Goid)
+	}
+	if len(ids) != 2 || ids[0] <= 0 || ids[1] <= 0 || ids[0] == ids[1] {
+		t.Errorf("expected: %v, got: %v", "two different ids", ids)
+	}
+}
+
+func TestWithHostInfo(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.WithHostInfo(), ctxlog.NoTime())
+
+	log.Print(context.Background(), "foo")
+
+	host, _ := os.Hostname()
+	expected := fmt.Sprintf(`{"msg":"foo","host":%q,"pid":%d}`+"\n", host, os.Getpid())
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestLazy(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.MinLevel(ctxlog.LevelInfo), ctxlog.NoTime())
+	calls := 0
+	ctx := ctxlog.With(context.Background(), ctxlog.Lazy("n", func() any {
+		calls++
+		return calls
+	}))
+
+	log.Print(ctx, "foo", ctxlog.Level(ctxlog.LevelDebug))
+	log.Print(ctx, "bar")
+	log.Print(ctx, "baz", ctxlog.Lazy("p", func() any { panic("boom") }))
+
+	expected := `{"msg":"bar","n":1}` + "\n" + `{"msg":"baz","n":1,"p":"!PANIC: boom"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	if calls != 1 {
+		t.Errorf("expected: %v, got: %v", 1, calls)
+	}
+}
+
+func TestObject(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Redact("token"), ctxlog.SortKeys(true), ctxlog.NoTime())
+	fields := []ctxlog.Field{ctxlog.Str("name", "x"), ctxlog.Int("id", 7), ctxlog.Int("id", 8), ctxlog.Str("token", "secret")}
+	ctx := ctxlog.With(context.Background(), ctxlog.Object("user", fields...))
+
+	log.Print(ctx, "foo", ctxlog.Object("req", ctxlog.Object("user", ctxlog.Int("id", 1))))
+
+	expected := `{"msg":"foo","req":{"user":{"id":1}},"user":{"id":7,"name":"x","token":"[REDACTED]"}}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	// Fields passed to Object are not modified.
+	buf.Reset()
+	ctxlog.New(buf, ctxlog.NoTime()).Print(ctx, "bar")
+	expected = `{"msg":"bar","user":{"name":"x","id":7,"token":"secret"}}` + "\n"
+	got = buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestArray(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.NoTime())
+	ctx := context.Background()
+	fields := []ctxlog.Field{
+		ctxlog.Array("a", 1, "two", 3.5, nil, []int{4}),
+		ctxlog.Ints("ids", 1, 2),
+		ctxlog.Strs("tags", "x", "y z"),
+		ctxlog.Strs("empty"),
+	}
+
+	log.Print(ctx, "foo", fields...)
+	log = ctxlog.New(buf, ctxlog.Logfmt(), ctxlog.NoTime())
+	log.Print(ctx, "foo", fields...)
+
+	expected := `{"msg":"foo","a":[1,"two",3.5,null,[4]],"ids":[1,2],"tags":["x","y z"],"empty":[]}` + "\n" +
+		`msg=foo a="[1,\"two\",3.5,null,[4]]" ids=[1,2] tags="[\"x\",\"y z\"]" empty=[]` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+type failWriter struct{}
+
+func (failWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestOnError(t *testing.T) {
+	var errs []string
+	onError := ctxlog.OnError(func(err error) {
+		errs = append(errs, err.Error())
+	})
+	ctx := context.Background()
+
+	ctxlog.New(failWriter{}, onError).Print(ctx, "foo")
+	ctxlog.New(io.Discard, onError).Print(ctx, "bar")
+	log := ctxlog.New(failWriter{}, onError, ctxlog.Async(10))
+	log.Print(ctx, "baz")
+	log.Close()
+
+	expected := []string{"disk full", "disk full"}
+	if !reflect.DeepEqual(expected, errs) {
+		t.Errorf("expected: %v, got: %v", expected, errs)
+	}
+}
+
+func TestOnErrorAsyncPrint(t *testing.T) {
+	var (
+		log   *ctxlog.Log
+		calls atomic.Int64
+	)
+	ctx := context.Background()
+	log = ctxlog.New(failWriter{}, ctxlog.Async(1), ctxlog.OnError(func(err error) {
+		if calls.Add(1) > 1 {
+			return
+		}
+		// The queue holds a single line, printing more would block if lines were queued.
+		for i := 0; i < 10; i++ {
+			log.Print(ctx, "write failed", ctxlog.Error(err))
+		}
+		log.Sync()
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log.Print(ctx, "foo")
+		log.Close()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadlock in OnError callback")
+	}
+
+	expected := int64(11)
+	got := calls.Load()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestPrintErr(t *testing.T) {
+	ctx := context.Background()
+
+	err := ctxlog.New(failWriter{}).PrintErr(ctx, "foo")
+	if expected, got := "disk full", fmt.Sprint(err); expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	buf := new(bytes.Buffer)
+	err = ctxlog.New(buf, ctxlog.WithEncoder(failEncoder{}), ctxlog.NoTime()).PrintErr(ctx, "foo")
+	if expected, got := "not supported", fmt.Sprint(err); expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	expected := `{"msg":"ctxlog: json encode error","error":"not supported","orig_msg":"foo"}` + "\n"
+	if got := buf.String(); expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	if err := ctxlog.New(io.Discard).PrintErr(ctx, "foo"); err != nil {
+		t.Errorf("expected: %v, got: %v", nil, err)
+	}
+}
+
+func TestBytes(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ctx := context.Background()
+	payload := []byte{0xde, 0xad, 0xbe, 0xef}
+
+	ctxlog.New(buf, ctxlog.NoTime()).Print(ctx, "foo", ctxlog.Object("req", ctxlog.Bytes("payload", payload)))
+	ctxlog.New(buf, ctxlog.NoTime(), ctxlog.BytesEncoding(ctxlog.BytesHex, 2)).Print(ctx, "foo", ctxlog.Bytes("payload", payload))
+	ctxlog.New(buf, ctxlog.NoTime(), ctxlog.BytesEncoding(ctxlog.BytesBase64, 4)).Print(ctx, "foo", ctxlog.Bytes("payload", payload))
+
+	expected := `{"msg":"foo","req":{"payload":"deadbeef"}}` + "\n" +
+		`{"msg":"foo","payload":"dead...","payload_len":4}` + "\n" +
+		`{"msg":"foo","payload":"3q2+7w=="}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestMaxFieldLen(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.MaxFieldLen(3), ctxlog.NoTime())
+
+	log.Print(context.Background(), "foo", ctxlog.Str("a", "abc"), ctxlog.Str("b", "абвг"),
+		ctxlog.Value("c", "abcd"), ctxlog.Object("d", ctxlog.Str("e", "abcd")), ctxlog.Error(errors.New("failed")),
+		ctxlog.Int("n", 12345))
+
+	expected := `{"msg":"foo","a":"abc","b":"абв…(truncated)","c":"abc…(truncated)","d":{"e":"abc…(truncated)"},"error":"fai…(truncated)","n":12345}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestMaxLineBytes(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ctx := context.Background()
+	fields := []ctxlog.Field{
+		ctxlog.Str("a", "small"), ctxlog.Str("b", strings.Repeat("x", 50)), ctxlog.Str("c", strings.Repeat("y", 40)),
+		ctxlog.Error(errors.New("failed")),
+	}
+
+	log := ctxlog.New(buf, ctxlog.MaxLineBytes(100, ctxlog.OversizeTrim), ctxlog.NoTime())
+	log.Print(ctx, "foo", fields...)
+	log.Print(ctx, "short")
+	log.Print(ctx, strings.Repeat("z", 100))
+	log = ctxlog.New(buf, ctxlog.MaxLineBytes(100, ctxlog.OversizeDrop), ctxlog.NoTime())
+	log.Print(ctx, "bar", fields...)
+	log = ctxlog.New(buf, ctxlog.MaxLineBytes(100, ctxlog.OversizeTrim), ctxlog.SortAllKeys(true), ctxlog.NoTime())
+	log.Print(ctx, "foo", append(fields, ctxlog.Str("z", "1"))...)
+
+	expected := `{"msg":"foo","a":"small","error":"failed","truncated_fields":["b","c"]}` + "\n" + `{"msg":"short"}` + "\n" +
+		`{"a":"small","error":"failed","msg":"foo","truncated_fields":["b","c"],"z":"1"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestFatal(t *testing.T) {
+	buf := new(bytes.Buffer)
+	var code int
+	log := ctxlog.New(buf, ctxlog.Async(10), ctxlog.ExitFunc(func(c int) { code = c }), ctxlog.NoTime())
+
+	log.Fatal(context.Background(), "foo", ctxlog.Str("a", "b"))
+
+	expected := `{"msg":"foo","level":"fatal","a":"b"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	if code != 1 {
+		t.Errorf("expected: %v, got: %v", 1, code)
+	}
+	log.Close()
+}
+
+func TestPanic(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Async(10), ctxlog.NoTime())
+	defer log.Close()
+
+	var r any
+	func() {
+		defer func() {
+			r = recover()
+		}()
+		log.Panic(context.Background(), "foo", ctxlog.Str("a", "b"))
+	}()
+
+	if r != "foo" {
+		t.Errorf("expected: %v, got: %v", "foo", r)
+	}
+	expected := `{"msg":"foo","level":"error","a":"b"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+// logInfo is a wrapper around ctxlog, like a company logging package.
+func logInfo(log *ctxlog.Log, ctx context.Context, msg string) {
+	log.Print(ctx, msg, ctxlog.Skip(1))
+}
+
+func TestSkip(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.WithCaller(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	_, _, line, _ := runtime.Caller(0)
+	logInfo(log, ctx, "foo")
+
+	expected := fmt.Sprintf(`{"msg":"foo","caller":"log_test.go:%d"}`+"\n", line+1)
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestWithSampling(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.NoTime())
+	ctx := ctxlog.WithSampling(context.Background(), false)
+
+	log.Print(ctx, "foo")
+	log.Print(ctx, "bar", ctxlog.Level(ctxlog.LevelError))
+	log.Print(ctxlog.WithSampling(ctx, true), "baz")
+	ctxlog.New(buf, ctxlog.NoTime(), ctxlog.SampledOutErrors(false)).Print(ctx, "qux", ctxlog.Level(ctxlog.LevelError))
+
+	expected := `{"msg":"bar","level":"error"}` + "\n" + `{"msg":"baz"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestFieldFromEnv(t *testing.T) {
+	buf := new(bytes.Buffer)
+	t.Setenv("CTXLOG_TEST_ENV", "production")
+	log := ctxlog.New(buf, ctxlog.FieldFromEnv("env", "CTXLOG_TEST_ENV"), ctxlog.FieldFromEnv("region", "CTXLOG_TEST_UNSET"), ctxlog.NoTime())
+	os.Setenv("CTXLOG_TEST_ENV", "staging")
+
+	log.Print(context.Background(), "foo")
+
+	expected := `{"msg":"foo","env":"production"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestWithSequence(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.WithSequence(), ctxlog.Async(10), ctxlog.MinLevel(ctxlog.LevelInfo), ctxlog.NoTime())
+	ctx := context.Background()
+
+	log.Print(ctx, "foo")
+	log.Print(ctx, "bar", ctxlog.Level(ctxlog.LevelDebug))
+	log.Named("db").Print(ctx, "baz")
+	log.Close()
+
+	expected := `{"msg":"foo","seq":1}` + "\n" + `{"msg":"baz","component":"db","seq":2}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestCountByMsg(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.CountByMsg(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	log.Print(ctx, "foo")
+	log.Print(ctx, "bar")
+	log.Named("db").Print(ctx, "foo")
+
+	expected := `{"msg":"foo","count":1}` + "\n" + `{"msg":"bar","count":1}` + "\n" +
+		`{"msg":"foo","component":"db","count":2}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	// The least recently printed messages are forgotten.
+	for i := 0; i < 1024; i++ {
+		log.Print(ctx, fmt.Sprint("msg", i))
+	}
+	buf.Reset()
+	log.Print(ctx, "foo")
+
+	expected = `{"msg":"foo","count":1}` + "\n"
+	got = buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestNumericLevel(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ctx := context.Background()
+
+	ctxlog.New(buf, ctxlog.NumericLevel("severity", nil), ctxlog.NoTime()).Print(ctx, "foo", ctxlog.Level(ctxlog.LevelWarn))
+	ranks := map[string]int{"warn": 4}
+	log := ctxlog.New(buf, ctxlog.NumericLevel("", ranks), ctxlog.Logfmt(), ctxlog.NoTime())
+	ranks["warn"] = 5 // ranks are copied by New
+	log.Print(ctx, "foo", ctxlog.Level(ctxlog.LevelWarn))
+	ctxlog.New(buf, ctxlog.NumericLevel("", nil), ctxlog.NoTime()).Print(ctx, "foo")
+
+	expected := `{"msg":"foo","severity":30}` + "\n" + "msg=foo level=4\n" + `{"msg":"foo"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestLineTerminator(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ctx := context.Background()
+
+	ctxlog.New(buf, ctxlog.LineTerminator("\r\n"), ctxlog.NoTime()).Print(ctx, "foo")
+	ctxlog.New(buf, ctxlog.LineTerminator(""), ctxlog.Logfmt(), ctxlog.NoTime()).Print(ctx, "bar")
+	ctxlog.New(buf, ctxlog.LineTerminator(";"), ctxlog.WithEncoder(failEncoder{}), ctxlog.NoTime()).Print(ctx, "baz")
+
+	expected := `{"msg":"foo"}` + "\r\n" + "msg=bar" + `{"msg":"ctxlog: json encode error","error":"not supported","orig_msg":"baz"};`
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestPrettyJSON(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.PrettyJSON(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	log.Print(ctx, "foo", ctxlog.Object("user", ctxlog.Int("id", 7)))
+	log.Print(ctx, "bar")
+
+	expected := "{\n  \"msg\": \"foo\",\n  \"user\": {\n    \"id\": 7\n  }\n}\n{\n  \"msg\": \"bar\"\n}\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestPoolHints(t *testing.T) {
+	buf := new(bytes.Buffer)
+	hook := ctxlog.Hook(func(ctx context.Context, level, msg string, fields map[string]any) {
+		fields["hooked"] = true
+	})
+	log := ctxlog.New(buf, ctxlog.PoolHints(32, 4096), ctxlog.Async(10), hook, ctxlog.NoTime())
+	ctx := context.Background()
+
+	log.Print(ctx, "foo")
+	b := log.Batch(ctx)
+	b.Print("baz")
+	b.Commit()
+	log.Close()
+
+	expected := `{"msg":"foo","hooked":true}` + "\n" + `{"msg":"baz","hooked":true}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.NoTime(), ctxlog.Filter(func(ctx context.Context, level, msg string, fields map[string]any) bool {
+		return fields["path"] != "/health"
+	}), ctxlog.Filter(func(ctx context.Context, level, msg string, fields map[string]any) bool {
+		return !strings.HasPrefix(msg, "noisy")
+	}), ctxlog.Filter(func(ctx context.Context, level, msg string, fields map[string]any) bool {
+		panic("boom")
+	}))
+	ctx := context.Background()
+
+	log.Print(ctx, "request", ctxlog.Str("path", "/health"))
+	log.Print(ctx, "request", ctxlog.Str("path", "/users"))
+	log.Print(ctx, "noisy library message")
+
+	expected := `{"msg":"request","path":"/users"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestWarnOnFormatVerbs(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.WarnOnFormatVerbs(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	log.Print(ctx, "user %s logged in")
+	log.Print(ctx, "user %s logged in")
+	log.Print(ctx, "100% done, 100%% sure")
+	log.Print(ctx, "GET /a%20b/100%full")
+	log.Print(ctx, "took %02dms")
+
+	expected := `{"msg":"ctxlog: message contains format verbs, use fields instead","level":"warn","orig_msg":"user %s logged in"}` + "\n" +
+		`{"msg":"user %s logged in"}` + "\n" + `{"msg":"user %s logged in"}` + "\n" + `{"msg":"100% done, 100%% sure"}` + "\n" +
+		`{"msg":"GET /a%20b/100%full"}` + "\n" +
+		`{"msg":"ctxlog: message contains format verbs, use fields instead","level":"warn","orig_msg":"took %02dms"}` + "\n" +
+		`{"msg":"took %02dms"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestHumanize(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ctx := context.Background()
+	fields := []ctxlog.Field{
+		ctxlog.HumanDuration("took", 1500*time.Millisecond),
+		ctxlog.HumanBytes("size", 1234567),
+		ctxlog.HumanBytes("small", 512),
+	}
+
+	ctxlog.New(buf, ctxlog.NoTime()).Print(ctx, "foo", fields...)
+	ctxlog.New(buf, ctxlog.Humanize(), ctxlog.NoTime()).Print(ctx, "foo", fields...)
+
+	expected := `{"msg":"foo","took":1500000000,"size":1234567,"small":512}` + "\n" +
+		`{"msg":"foo","took":"1.5s","size":"1.2 MB","small":"512 B"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestHumanizeOutputs(t *testing.T) {
+	jsonBuf, consoleBuf := new(bytes.Buffer), new(bytes.Buffer)
+	ctx := context.Background()
+	fields := []ctxlog.Field{
+		ctxlog.HumanDuration("took", 1500*time.Millisecond),
+		ctxlog.Object("resp", ctxlog.HumanBytes("size", 1234567)),
+	}
+
+	// Every output is humanized according to its own format.
+	ctxlog.New(consoleBuf, ctxlog.Printer(ctxlog.FormatConsole), ctxlog.AddOutput(jsonBuf, ctxlog.FormatJSON), ctxlog.NoTime()).
+		Print(ctx, "foo", fields...)
+	ctxlog.New(jsonBuf, ctxlog.AddOutput(consoleBuf, ctxlog.FormatConsole), ctxlog.NoTime()).
+		Print(ctx, "foo", fields...)
+
+	expected := `{"msg":"foo","took":1500000000,"resp":{"size":1234567}}` + "\n" +
+		`{"msg":"foo","took":1500000000,"resp":{"size":1234567}}` + "\n"
+	got := jsonBuf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	expected = `foo took=1.5s resp.size="1.2 MB"` + "\n" + `foo took=1.5s resp.size="1.2 MB"` + "\n"
+	got = consoleBuf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestErrorMarshaler(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.NoTime(), ctxlog.ErrorMarshaler(func(err error) any {
+		return map[string]any{"msg": err.Error(), "type": fmt.Sprintf("%T", err)}
+	}))
+
+	pc := make([]uintptr, 1)
+	runtime.Callers(1, pc)
+	log.Print(context.Background(), "foo", ctxlog.Error(&stackError{msg: "failed", pc: pc}))
+
+	var got struct {
+		Error      map[string]string
+		ErrorStack []string `json:"error_stack"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"msg": "failed", "type": "*ctxlog_test.stackError"}
+	if !reflect.DeepEqual(expected, got.Error) {
+		t.Errorf("expected: %v, got: %v", expected, got.Error)
+	}
+	if len(got.ErrorStack) == 0 {
+		t.Errorf("expected: %v, got: %v", "error_stack", buf.String())
+	}
+}
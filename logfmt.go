@@ -0,0 +1,246 @@
+package ctxlog
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// appendLogfmt appends e to b as a logfmt line.
+// Keys are written in the same order as in json.
+func (l *Log) appendLogfmt(b []byte, e *entry) ([]byte, error) {
+	start := len(b)
+	i := 0
+	keys, n := l.ownKeys(e)
+	for _, k := range keys[:n] {
+		b, _ = appendLogfmtFields(b, "", e.fields[i:k.at], false)
+		i = k.at
+
+		b = append(b, ' ')
+		b = appendLogfmtKey(b, "", k.key)
+		switch k.kind {
+		case keyMsg:
+			b = appendLogfmtString(b, e.msg)
+		case keyLevel:
+			if rank, ok := l.numericLevel(e.level); ok {
+				b = strconv.AppendInt(b, int64(rank), 10)
+			} else {
+				b = appendLogfmtString(b, e.level)
+			}
+		case keyTime:
+			b = l.appendLogfmtTime(b, e.time)
+		}
+	}
+	b, _ = appendLogfmtFields(b, "", e.fields[i:], false)
+
+	b = append(b, '\n')
+	if b[start] == ' ' {
+		b = append(b[:start], b[start+1:]...)
+	}
+	return b, nil
+}
+
+// appendLogfmtError appends line describing encoding error err instead of e.
+func (l *Log) appendLogfmtError(b []byte, e *entry, err error) []byte {
+	b = appendLogfmtKey(b, "", l.msgKey)
+	b = appendLogfmtString(b, "ctxlog: logfmt encode error")
+	b = append(b, ' ')
+	b = appendLogfmtKey(b, "", "error")
+	b = appendLogfmtString(b, err.Error())
+	b = append(b, ' ')
+	b = appendLogfmtKey(b, "", "orig_msg")
+	b = appendLogfmtString(b, e.msg)
+	if e.hasTime {
+		b = append(b, ' ')
+		b = appendLogfmtKey(b, "", l.timeKey)
+		b = l.appendLogfmtTime(b, e.time)
+	}
+	b = append(b, '\n')
+	return b
+}
+
+// appendLogfmtTime appends time of the message in format set by TimeFormat, RFC3339 by default.
+func (l *Log) appendLogfmtTime(b []byte, t time.Time) []byte {
+	switch l.timeFormat {
+	case "":
+		return t.AppendFormat(b, time.RFC3339)
+	case TimeFormatUnix:
+		return strconv.AppendInt(b, t.Unix(), 10)
+	case TimeFormatUnixMilli:
+		return strconv.AppendInt(b, t.UnixMilli(), 10)
+	default:
+		return appendLogfmtString(b, t.Format(l.timeFormat))
+	}
+}
+
+// appendLogfmtFields appends fs as key=value pairs, each one preceded by space.
+// Values which can't be encoded are replaced with key_error=message.
+// Objects are flattened, their fields are prefixed with the object key and '.'.
+// If color is set, keys are highlighted for the console.
+func appendLogfmtFields(b []byte, prefix string, fs []Field, color bool) ([]byte, error) {
+	var err error
+	for _, f := range fs {
+		if f.kind == kindObject {
+			b, err = appendLogfmtFields(b, prefix+f.key+".", f.val.([]Field), color)
+			if err != nil {
+				return b, err
+			}
+			continue
+		}
+
+		b = append(b, ' ')
+		start := len(b)
+		b = colorStart(b, color, colorCyan)
+		b = appendLogfmtKey(b, prefix, f.key)
+		b = colorEnd(b, color)
+		b, err = appendLogfmtValue(b, f)
+		if err != nil {
+			// Replace value which can't be encoded with key_error=message.
+			b = colorStart(b[:start], color, colorCyan)
+			b = appendLogfmtKey(b, prefix, f.key+"_error")
+			b = colorEnd(b, color)
+			b = appendLogfmtString(b, err.Error())
+		}
+	}
+	return b, nil
+}
+
+// appendLogfmtKey appends prefix and key followed by '='.
+// Characters which are not allowed in keys are replaced with '_'.
+func appendLogfmtKey(b []byte, prefix, key string) []byte {
+	for _, s := range [2]string{prefix, key} {
+		for i := 0; i < len(s); i++ {
+			c := s[i]
+			if c <= ' ' || c == '=' || c == '"' || c == 0x7f {
+				c = '_'
+			}
+			b = append(b, c)
+		}
+	}
+	return append(b, '=')
+}
+
+func appendLogfmtValue(b []byte, f Field) ([]byte, error) {
+	switch f.kind {
+	case kindString:
+		return appendLogfmtString(b, f.str), nil
+	case kindInt64, kindHumanDuration, kindHumanBytes:
+		return strconv.AppendInt(b, f.num, 10), nil
+	case kindFloat64:
+		return appendLogfmtFloat(b, math.Float64frombits(uint64(f.num)), 64), nil
+	case kindBool:
+		return strconv.AppendBool(b, f.num == 1), nil
+	case kindDuration:
+		return append(b, time.Duration(f.num).String()...), nil
+	case kindArray:
+		// Arrays are printed as json, quoted if needed.
+		start := len(b)
+		b, err := appendJSONArray(b, f.val)
+		if err != nil {
+			return b[:start], err
+		}
+		return appendLogfmtString(b[:start], string(b[start:])), nil
+	}
+	return appendLogfmtAny(b, f.val)
+}
+
+// appendLogfmtAny appends v, nil pointers are printed as null, like in json.
+// Panic in a method of v is returned as error, so the field is printed as key_error.
+func appendLogfmtAny(b []byte, v any) (p []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			p, err = b, fmt.Errorf("!PANIC: %v", r)
+		}
+	}()
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return append(b, "null"...), nil
+	}
+
+	switch v := v.(type) {
+	case nil:
+		return append(b, "null"...), nil
+	case string:
+		return appendLogfmtString(b, v), nil
+	case bool:
+		return strconv.AppendBool(b, v), nil
+	case int:
+		return strconv.AppendInt(b, int64(v), 10), nil
+	case int64:
+		return strconv.AppendInt(b, v, 10), nil
+	case int32:
+		return strconv.AppendInt(b, int64(v), 10), nil
+	case uint:
+		return strconv.AppendUint(b, uint64(v), 10), nil
+	case uint64:
+		return strconv.AppendUint(b, v, 10), nil
+	case uint32:
+		return strconv.AppendUint(b, uint64(v), 10), nil
+	case float64:
+		return appendLogfmtFloat(b, v, 64), nil
+	case float32:
+		return appendLogfmtFloat(b, float64(v), 32), nil
+	case time.Time:
+		return v.AppendFormat(b, time.RFC3339), nil
+	case encoding.TextMarshaler:
+		p, err := v.MarshalText()
+		if err != nil {
+			return b, err
+		}
+		return appendLogfmtString(b, string(p)), nil
+	case json.Marshaler:
+		// Values are printed as they are in json, json strings unquoted.
+		p, err := json.Marshal(v)
+		if err != nil {
+			return b, err
+		}
+		var s string
+		if json.Unmarshal(p, &s) == nil {
+			return appendLogfmtString(b, s), nil
+		}
+		return appendLogfmtString(b, string(p)), nil
+	case error:
+		return appendLogfmtString(b, v.Error()), nil
+	case fmt.Stringer:
+		return appendLogfmtString(b, v.String()), nil
+	default:
+		p, err := json.Marshal(v)
+		if err != nil {
+			return b, err
+		}
+		return appendLogfmtString(b, string(p)), nil
+	}
+}
+
+func appendLogfmtFloat(b []byte, f float64, bits int) []byte {
+	p, err := appendJSONFloat(b, f, bits)
+	if err != nil {
+		return strconv.AppendFloat(b, f, 'g', -1, bits)
+	}
+	return p
+}
+
+// appendLogfmtString appends s, quoting it if it contains spaces, quotes, '=' or non-printable characters.
+func appendLogfmtString(b []byte, s string) []byte {
+	if !needsQuote(s) {
+		return append(b, s...)
+	}
+	return strconv.AppendQuote(b, s)
+}
+
+func needsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
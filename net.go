@@ -0,0 +1,135 @@
+package ctxlog
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// maxNetLines is the number of lines buffered by network writers while collector is unavailable.
+	maxNetLines = 1000
+
+	netDialTimeout  = time.Second
+	netWriteTimeout = time.Second
+	netMinBackoff   = 100 * time.Millisecond
+	netMaxBackoff   = 30 * time.Second
+)
+
+// TCPWriter returns writer which sends lines to a collector like Logstash or Vector at addr over TCP.
+// Connection is established on the first write. If it fails or breaks, the writer reconnects
+// with exponential backoff, meanwhile up to 1000 lines are buffered, the oldest are dropped first.
+// If collector doesn't read, writes time out after a second and are retried with backoff as well.
+// Connecting and sending happen during writes, use Async to not block callers.
+func TCPWriter(addr string) io.WriteCloser {
+	return &netWriter{network: "tcp", addr: addr}
+}
+
+// UDPWriter returns writer which sends every line to addr as a separate UDP datagram, see TCPWriter.
+func UDPWriter(addr string) io.WriteCloser {
+	return &netWriter{network: "udp", addr: addr}
+}
+
+type netWriter struct {
+	network string
+	addr    string
+
+	mu      sync.Mutex
+	conn    net.Conn
+	lines   [][]byte
+	sent    int // bytes of lines[0] sent before write timed out
+	backoff time.Duration
+	nextTry time.Time
+	closed  bool
+}
+
+// Write queues lines of p and sends queued lines if collector is available.
+// p may contain several lines, for example written by Async or Batch, each of them is sent separately.
+// Lines which can't be sent yet are kept, so Write only fails after Close.
+func (w *netWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, net.ErrClosed
+	}
+	for rest := p; len(rest) > 0; {
+		n := bytes.IndexByte(rest, '\n') + 1
+		if n == 0 {
+			n = len(rest)
+		}
+		if len(w.lines) == maxNetLines {
+			// The oldest line is dropped, unless it is partially sent.
+			if w.sent > 0 {
+				w.lines[1] = w.lines[0]
+			}
+			w.lines[0] = nil
+			w.lines = w.lines[1:]
+		}
+		w.lines = append(w.lines, append([]byte(nil), rest[:n]...))
+		rest = rest[n:]
+	}
+	w.send()
+	return len(p), nil
+}
+
+// send writes queued lines, connecting first if needed.
+// If write times out, the connection is kept and the rest of the line is sent on the next try,
+// if it fails otherwise, the line is sent again over a new connection.
+func (w *netWriter) send() {
+	if time.Now().Before(w.nextTry) {
+		return
+	}
+	if w.conn == nil {
+		conn, err := net.DialTimeout(w.network, w.addr, netDialTimeout)
+		if err != nil {
+			w.retryLater()
+			return
+		}
+		w.conn = conn
+	}
+
+	for len(w.lines) > 0 {
+		w.conn.SetWriteDeadline(time.Now().Add(netWriteTimeout))
+		n, err := w.conn.Write(w.lines[0][w.sent:])
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				w.sent += n
+			} else {
+				w.conn.Close()
+				w.conn, w.sent = nil, 0
+			}
+			w.retryLater()
+			return
+		}
+		w.lines[0] = nil
+		w.lines, w.sent = w.lines[1:], 0
+	}
+	w.backoff = 0
+}
+
+// retryLater postpones the next send with exponential backoff.
+func (w *netWriter) retryLater() {
+	w.backoff = min(max(2*w.backoff, netMinBackoff), netMaxBackoff)
+	w.nextTry = time.Now().Add(w.backoff)
+}
+
+// Close sends queued lines if possible and closes the connection.
+func (w *netWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.nextTry = time.Time{}
+	w.send()
+	w.lines = nil
+	if w.conn == nil {
+		return nil
+	}
+	return w.conn.Close()
+}
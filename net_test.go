@@ -0,0 +1,132 @@
+package ctxlog_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/kaey/ctxlog"
+)
+
+func TestTCPWriterReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	w := ctxlog.TCPWriter(addr)
+	defer w.Close()
+	log := ctxlog.New(w, ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	// Collector is down, lines are buffered.
+	log.Print(ctx, "1")
+	log.Print(ctx, "2")
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	time.Sleep(200 * time.Millisecond)
+	log.Print(ctx, "3")
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, expected := range []string{"msg=1\n", "msg=2\n", "msg=3\n"} {
+		got, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected != got {
+			t.Errorf("expected: %v, got: %v", expected, got)
+		}
+	}
+}
+
+func TestTCPWriterStalled(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	w := ctxlog.TCPWriter(ln.Addr().String())
+	defer w.Close()
+
+	// Collector doesn't read, writes time out instead of blocking.
+	big := append(bytes.Repeat([]byte("x"), 32<<20), '\n')
+	start := time.Now()
+	w.Write(big)
+	w.Write([]byte("y\n"))
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("expected: %v, got: %v", "write timeout", elapsed)
+	}
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(io.LimitReader(conn, int64(len(big))+4))
+		done <- b
+	}()
+
+	// Collector reads again, the rest of the line is sent once.
+	time.Sleep(200 * time.Millisecond)
+	w.Write([]byte("z\n"))
+	got := <-done
+	if expected := string(big) + "y\nz\n"; expected != string(got) {
+		t.Errorf("expected: %v bytes, got: %v bytes", len(expected), len(got))
+	}
+}
+
+func TestUDPWriterAsync(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	w := ctxlog.UDPWriter(conn.LocalAddr().String())
+	defer w.Close()
+	log := ctxlog.New(w, ctxlog.Logfmt(), ctxlog.NoTime(), ctxlog.Async(100))
+	ctx := context.Background()
+
+	// Lines queued together are written with a single Write call.
+	b := log.Batch(ctx)
+	for i := 0; i < 50; i++ {
+		b.Print(strconv.Itoa(i))
+	}
+	b.Commit()
+	log.Close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64*1024)
+	for i := 0; i < 50; i++ {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "msg=" + strconv.Itoa(i) + "\n"
+		got := string(buf[:n])
+		if expected != got {
+			t.Errorf("expected: %v, got: %v", expected, got)
+		}
+	}
+}
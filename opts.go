@@ -0,0 +1,624 @@
+package ctxlog
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"maps"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Option configures Log created with New.
+// Field is an Option as well, it adds default field to every message.
+type Option interface {
+	apply(l *Log)
+}
+
+type optionFunc func(l *Log)
+
+func (f optionFunc) apply(l *Log) {
+	f(l)
+}
+
+func (f Field) apply(l *Log) {
+	l.fields = append(l.fields, f)
+}
+
+// DefaultFields adds fields to every message, like passing each of them to New.
+// It adapts slices of fields which were passed to New before it took options: New(w, DefaultFields(fs...)).
+func DefaultFields(fs ...Field) Option {
+	return optionFunc(func(l *Log) {
+		l.fields = append(l.fields, fs...)
+	})
+}
+
+// MinLevel drops messages with level below level before they are encoded.
+// Messages without level are treated as info. WithLevel overrides it for a context.
+func MinLevel(level string) Option {
+	return optionFunc(func(l *Log) {
+		l.minLevel = levelRank(level)
+	})
+}
+
+// Format is an encoding of log lines.
+type Format int
+
+const (
+	// FormatJSON writes every message as a json object on its own line. This is the default.
+	FormatJSON Format = iota
+	// FormatLogfmt writes every message as key=value pairs on its own line.
+	FormatLogfmt
+	// FormatConsole writes human readable lines for local development.
+	// Lines are colored when the writer is a terminal, see ForceColor.
+	FormatConsole
+)
+
+// Printer sets format of log lines.
+func Printer(format Format) Option {
+	return optionFunc(func(l *Log) {
+		l.format = format
+	})
+}
+
+// Logfmt is a shortcut for Printer(FormatLogfmt).
+func Logfmt() Option {
+	return Printer(FormatLogfmt)
+}
+
+// WithEncoder encodes messages with enc instead of the format set by Printer.
+// Outputs added by AddOutput keep their own formats.
+func WithEncoder(enc Encoder) Option {
+	return optionFunc(func(l *Log) {
+		l.encoder = enc
+	})
+}
+
+// AddOutput adds another writer with its own format, for example json to a file and console to stdout.
+// Fields are collected once and every output encodes them independently.
+// Writes to all outputs happen under a single lock.
+func AddOutput(w io.Writer, format Format) Option {
+	return optionFunc(func(l *Log) {
+		l.outputs = append(l.outputs, output{w: w, format: format})
+	})
+}
+
+// LevelOutput adds another writer which receives only messages with level at or above minLevel,
+// for example errors to stderr in addition to everything to stdout.
+// Messages dropped by MinLevel are not written to it either.
+// Lines are encoded in format of the primary writer once and the same bytes are written to both.
+func LevelOutput(minLevel string, w io.Writer) Option {
+	return optionFunc(func(l *Log) {
+		l.outputs = append(l.outputs, output{w: w, minLevel: levelRank(minLevel), primary: true})
+	})
+}
+
+// Async makes Print return after the message is encoded, writes happen in a background goroutine,
+// so slow writers don't block callers. Up to bufferSize messages are queued,
+// when the queue is full Print blocks until there is space, see AsyncDrop.
+// Use defer log.Close() in main to write queued messages before exit.
+func Async(bufferSize int) Option {
+	return optionFunc(func(l *Log) {
+		l.asyncSize = bufferSize
+	})
+}
+
+// AsyncDrop makes Print drop messages instead of blocking when the Async queue is full.
+// Number of dropped messages is returned by Log.Dropped.
+func AsyncDrop() Option {
+	return optionFunc(func(l *Log) {
+		l.asyncDrop = true
+	})
+}
+
+// PoolHints sets initial capacity of fields collected for a message and of maps passed to hooks to mapCap fields
+// and initial capacity of buffers of encoded lines to bufCap bytes, 10 fields and 0 bytes by default.
+// Set them to the typical size of messages to avoid growing maps and buffers under bursty load.
+// Log with hints uses its own pools, zero value keeps the default.
+func PoolHints(mapCap, bufCap int) Option {
+	return optionFunc(func(l *Log) {
+		if mapCap > 0 {
+			l.mapPool = &sync.Pool{New: func() any {
+				return make(map[string]any, mapCap)
+			}}
+			l.entPool = &sync.Pool{New: func() any {
+				return &entry{fields: make([]Field, 0, mapCap), call: make([]Field, 0, mapCap)}
+			}}
+		}
+		if bufCap > 0 {
+			l.bufPool = &sync.Pool{New: func() any {
+				return bytes.NewBuffer(make([]byte, 0, bufCap))
+			}}
+		}
+	})
+}
+
+// ForceColor enables or disables colors of FormatConsole regardless of the writers.
+func ForceColor(color bool) Option {
+	return optionFunc(func(l *Log) {
+		l.color = color
+		l.colorSet = true
+	})
+}
+
+// WithCaller adds "caller" field with file name and line of the Print call, like "main.go:42".
+func WithCaller() Option {
+	return optionFunc(func(l *Log) {
+		l.caller = true
+	})
+}
+
+// Redact replaces values of fields with specified keys with "[REDACTED]".
+// Keys are matched case-insensitively. Objects and groups with matching keys are redacted as a whole.
+func Redact(keys ...string) Option {
+	return optionFunc(func(l *Log) {
+		l.redactKeys = append(l.redactKeys, keys...)
+	})
+}
+
+// RedactFunc calls fn for every field, if fn returns true, field value is replaced with returned one.
+// For objects and groups fn is passed map[string]any, fields in them are passed to fn unless it is replaced.
+// It can be used for custom masking, like keeping only last digits of a card number.
+func RedactFunc(fn func(key string, val any) (any, bool)) Option {
+	return optionFunc(func(l *Log) {
+		l.redactFuncs = append(l.redactFuncs, fn)
+	})
+}
+
+// Sample prints only 1 of every n messages with the same msg, others are dropped.
+// Number of dropped messages is added to the next printed one as "sampled_dropped" field.
+// Counters are kept per msg, so msg should not contain variable data,
+// up to 1024 messages are remembered, least recently printed ones are counted from the start again.
+func Sample(n int) Option {
+	return optionFunc(func(l *Log) {
+		if n > 1 {
+			l.sampler = &sampler{n: uint64(n), msgs: newMsgCounter()}
+		}
+	})
+}
+
+// SampledOutErrors sets whether messages at error level and above are printed
+// with contexts sampled out by WithSampling, true by default.
+func SampledOutErrors(print bool) Option {
+	return optionFunc(func(l *Log) {
+		l.dropErrs = !print
+	})
+}
+
+// MaxStackDepth limits number of frames in "error_stack" field to n.
+func MaxStackDepth(n int) Option {
+	return optionFunc(func(l *Log) {
+		l.maxStackDepth = n
+	})
+}
+
+// StructuredStack prints frames of "error_stack" field as objects with "file", "line" and "func" keys
+// instead of strings like "/src/main.go:42[main.main]".
+func StructuredStack() Option {
+	return optionFunc(func(l *Log) {
+		l.structuredStack = true
+	})
+}
+
+// StackOnError adds "error_stack" field with stack of the Print call to messages at error level and above,
+// unless the error already has a stack, see Stacker.
+func StackOnError() Option {
+	return optionFunc(func(l *Log) {
+		l.stackOnError = true
+	})
+}
+
+// Dedup suppresses lines with the same msg and error repeated within window after the first one.
+// Number of suppressed lines is reported when another line is printed, when the window closes or on Sync,
+// by printing the first suppressed line with "repeated" field.
+// Unlike Sample, different lines are never dropped, it only collapses bursts of identical ones.
+// Up to 1024 distinct lines are remembered, least recently printed ones are forgotten first.
+func Dedup(window time.Duration) Option {
+	return optionFunc(func(l *Log) {
+		if window > 0 {
+			l.deduper = newDeduper(window)
+		}
+	})
+}
+
+// LastWins changes resolution of repeated keys within a single New, With or Print call:
+// the last field wins instead of the first one.
+// Fields of more specific calls still override less specific ones regardless of this option,
+// see With.
+func LastWins() Option {
+	return optionFunc(func(l *Log) {
+		l.lastWins = true
+	})
+}
+
+// MergeKeys makes fields with listed keys accumulate: values of all fields with the same key
+// are printed as an array, from the least specific to the most specific, errors as their messages,
+// instead of the most specific one winning. The array is printed even if there is a single value.
+// Error, time, level and caller fields can't be merged.
+func MergeKeys(keys ...string) Option {
+	return optionFunc(func(l *Log) {
+		l.mergeKeys = append(l.mergeKeys, keys...)
+	})
+}
+
+// KeyPolicy resolves collisions of field keys with keys printed by Log itself:
+// "level", "error_chain", "error_stack", msg key and time key, see ReservedKeyPolicy.
+type KeyPolicy int
+
+const (
+	// KeyPolicyIgnore drops fields which collide with msg, level and time keys of the message,
+	// so they can't replace the message in decoders which keep the last of duplicate keys. This is the default.
+	// Field named time is still dropped unless it is a time, which sets time of the message,
+	// fields named error_chain and error_stack replace ones made from the error.
+	KeyPolicyIgnore KeyPolicy = iota
+	// KeyPolicyRename prints fields with reserved keys prefixed with "fields.", like "fields.msg".
+	// Level and Time fields still set level and time of the message.
+	KeyPolicyRename
+	// KeyPolicyPrefix prints keys of Log itself prefixed with "ctxlog.", like "ctxlog.msg",
+	// if there is a field with the same key in the message.
+	KeyPolicyPrefix
+)
+
+// ReservedKeyPolicy sets how fields with keys printed by Log itself are handled, see KeyPolicy.
+func ReservedKeyPolicy(policy KeyPolicy) Option {
+	return optionFunc(func(l *Log) {
+		l.keyPolicy = policy
+	})
+}
+
+// SortKeys prints fields sorted by key, including fields of groups, for deterministic output like golden tests.
+// By default fields are printed in order they were added, see With.
+// Msg and level are still printed first and time last, use SortAllKeys to sort them as well.
+func SortKeys(sort bool) Option {
+	return optionFunc(func(l *Log) {
+		l.sortKeys = sort
+		l.sortAll = false
+	})
+}
+
+// SortAllKeys is like SortKeys, but msg, level and time are sorted along with other fields,
+// so keys of a line are in alphabetical order.
+// It applies to FormatJSON, FormatLogfmt and WithEncoder, FormatConsole keeps its layout.
+func SortAllKeys(sort bool) Option {
+	return optionFunc(func(l *Log) {
+		l.sortKeys = sort
+		l.sortAll = sort
+	})
+}
+
+// Hook registers fn to be called for every printed message after its fields are collected and before it is encoded.
+// fields contains all fields except msg, level and time, fn can modify it to add, change or remove fields.
+// Hooks are called in order they were registered, panic in a hook is recovered and ignored.
+// fields is reused for later messages once fn returns, so fn must not keep it or maps nested in it,
+// copy them or register fn with RetainingHook instead.
+func Hook(fn func(ctx context.Context, level, msg string, fields map[string]any)) Option {
+	return TimeHook(func(ctx context.Context, _ time.Time, level, msg string, fields map[string]any) {
+		fn(ctx, level, msg, fields)
+	})
+}
+
+// TimeHook is Hook for fn which needs time of the message, t is the time printed under time key,
+// or zero if the message is printed without time, see NoTime and Clock.
+func TimeHook(fn func(ctx context.Context, t time.Time, level, msg string, fields map[string]any)) Option {
+	return optionFunc(func(l *Log) {
+		l.hooks = append(l.hooks, fn)
+	})
+}
+
+// RetainingHook is Hook for fn which keeps fields after it returns, for example to process them
+// in another goroutine. If it is registered, a new map is allocated for hooks of every message
+// instead of reusing one, fields of filters are reused as before.
+func RetainingHook(fn func(ctx context.Context, level, msg string, fields map[string]any)) Option {
+	return optionFunc(func(l *Log) {
+		Hook(fn).apply(l)
+		l.retainHook = true
+	})
+}
+
+// Filter registers fn to be called for every message after its fields are collected and redacted,
+// the message is dropped if fn returns false, for example to drop health check requests by path field.
+// fields contains all fields except msg, level and time and must not be modified or kept after fn returns.
+// Filters are called before Hook and before the message is encoded, panic in a filter is recovered
+// and the message is printed.
+func Filter(fn func(ctx context.Context, level, msg string, fields map[string]any) bool) Option {
+	return optionFunc(func(l *Log) {
+		l.filters = append(l.filters, fn)
+	})
+}
+
+// LevelMetrics registers fn to be called with level of every printed message, for example to count messages
+// by level with a prometheus counter. Messages without level are counted as info,
+// messages which couldn't be encoded as error. Messages dropped by MinLevel, Sample or Dedup are not counted.
+// fn is called synchronously from Print and should be cheap.
+func LevelMetrics(fn func(level string)) Option {
+	return optionFunc(func(l *Log) {
+		l.levelMetrics = fn
+	})
+}
+
+// ErrorMarshaler sets function which returns value printed as "error" field instead of err.Error(),
+// for example a map with type and fields of custom errors. Error chain and stack are printed as before.
+func ErrorMarshaler(fn func(err error) any) Option {
+	return optionFunc(func(l *Log) {
+		l.marshalErr = fn
+	})
+}
+
+// OnError registers fn to be called when a writer returns an error, like a broken pipe or a full disk,
+// which is otherwise ignored by Print. With Async it is called from the background goroutine,
+// messages printed by fn with the same Log are then written synchronously, and Sync or Close called by fn
+// don't wait for the goroutine. Messages printed by fn with the same Log may fail again and call fn recursively.
+func OnError(fn func(err error)) Option {
+	return optionFunc(func(l *Log) {
+		l.onError = fn
+	})
+}
+
+// ExitFunc sets function called by Fatal instead of os.Exit, for example to use another exit code
+// or to test code which calls Fatal.
+func ExitFunc(fn func(code int)) Option {
+	return optionFunc(func(l *Log) {
+		l.exit = fn
+	})
+}
+
+// WithTraceContext adds "trace_id" and "span_id" fields returned by fn to every message.
+// No fields are added if fn returns false. For OpenTelemetry use:
+//
+//	ctxlog.WithTraceContext(func(ctx context.Context) (string, string, bool) {
+//		sc := trace.SpanContextFromContext(ctx)
+//		return sc.TraceID().String(), sc.SpanID().String(), sc.IsValid()
+//	})
+func WithTraceContext(fn func(ctx context.Context) (traceID, spanID string, ok bool)) Option {
+	return ContextExtractor(func(ctx context.Context) []Field {
+		traceID, spanID, ok := fn(ctx)
+		if !ok {
+			return nil
+		}
+		return []Field{Str("trace_id", traceID), Str("span_id", spanID)}
+	})
+}
+
+// WithContextState adds "ctx_err" field with ctx.Err() if context of the message is done
+// and "ctx_deadline" field with time remaining until its deadline if it has one.
+// It helps to find messages printed after request was canceled or timed out.
+func WithContextState() Option {
+	return ContextExtractor(func(ctx context.Context) []Field {
+		var fs []Field
+		if err := ctx.Err(); err != nil {
+			fs = append(fs, Str("ctx_err", err.Error()))
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			fs = append(fs, Dur("ctx_deadline", time.Until(deadline)))
+		}
+		return fs
+	})
+}
+
+// WithElapsed adds "elapsed_ms" field to every message printed with context returned by StartTimer, see Elapsed.
+func WithElapsed() Option {
+	return ContextExtractor(func(ctx context.Context) []Field {
+		if f := Elapsed(ctx); f.key != "" {
+			return []Field{f}
+		}
+		return nil
+	})
+}
+
+// WithHostInfo adds "host" field with os.Hostname and "pid" field with os.Getpid to every message.
+// They are read once when the Log is created. Host is omitted if os.Hostname fails.
+func WithHostInfo() Option {
+	return optionFunc(func(l *Log) {
+		if host, err := os.Hostname(); err == nil {
+			l.fields = append(l.fields, Str("host", host))
+		}
+		l.fields = append(l.fields, Int("pid", os.Getpid()))
+	})
+}
+
+// FieldFromEnv adds field key with value of environment variable envVar to every message,
+// like FieldFromEnv("env", "APP_ENV"). The variable is read once when the Log is created,
+// field is omitted if it is not set.
+func FieldFromEnv(key, envVar string) Option {
+	return optionFunc(func(l *Log) {
+		if v, ok := os.LookupEnv(envVar); ok {
+			l.fields = append(l.fields, Str(key, v))
+		}
+	})
+}
+
+// WithSequence adds "seq" field with number of the message, starting from 1, to every message,
+// to detect messages lost or reordered on the way to the log storage.
+// Logs returned by WithFields and Named share the counter with the parent.
+// Messages printed by a single goroutine are written in order of their numbers, with Async as well.
+func WithSequence() Option {
+	return optionFunc(func(l *Log) {
+		l.seq = new(atomic.Uint64)
+	})
+}
+
+// CountByMsg adds "count" field with the number of messages with the same msg printed so far
+// to every message, to find messages dominating the log during development.
+// Up to 1024 most recently printed distinct messages are counted.
+// Logs returned by WithFields and Named share the counts with the parent.
+func CountByMsg() Option {
+	return optionFunc(func(l *Log) {
+		l.counter = newMsgCounter()
+	})
+}
+
+// WarnOnFormatVerbs prints a warning with "orig_msg" field when msg contains format verbs like %s or %d,
+// which usually means values were meant to be printed, but fields should be used for that instead.
+// The warning is printed once for every distinct msg, up to 1024 most recently printed ones are remembered.
+func WarnOnFormatVerbs() Option {
+	return optionFunc(func(l *Log) {
+		l.verbs = newMsgCounter()
+	})
+}
+
+// WithGoroutineID adds "goid" field with id of the goroutine which printed the message,
+// to tell apart interleaved messages of concurrent workers. Getting the id is relatively expensive,
+// it takes a runtime.Stack call per message.
+func WithGoroutineID() Option {
+	return ContextExtractor(func(ctx context.Context) []Field {
+		if id, ok := goid(); ok {
+			return []Field{Int64("goid", id)}
+		}
+		return nil
+	})
+}
+
+// ContextExtractor adds fields returned by fn for context of every message,
+// like request id stored in context by other packages.
+// Extracted fields override fields of the Log and are overridden by fields added with With or Print.
+// Extractors are called in order they were registered, fields of later ones override earlier ones.
+func ContextExtractor(fn func(ctx context.Context) []Field) Option {
+	return optionFunc(func(l *Log) {
+		l.extractors = append(l.extractors, fn)
+	})
+}
+
+// MsgKey sets key of the message, "msg" by default.
+func MsgKey(key string) Option {
+	return optionFunc(func(l *Log) {
+		l.msgKey = key
+	})
+}
+
+// OmitEmptyMsg omits msg key of messages printed with empty msg, for example messages made of fields only.
+// Format console and Encoder are not affected, they are passed empty msg, except JSONEncoder.
+func OmitEmptyMsg() Option {
+	return optionFunc(func(l *Log) {
+		l.omitMsg = true
+	})
+}
+
+// NumericLevel prints level as a number under key, or under "level" if key is empty,
+// for storages which query numeric severity more efficiently. By default levels are printed as
+// debug=10, info=20, warn=30, error=40, fatal=50, ranks overrides numbers of some or all of them.
+// Format console keeps printing levels as strings. ranks is copied, so it can be reused after New.
+func NumericLevel(key string, ranks map[string]int) Option {
+	return optionFunc(func(l *Log) {
+		l.numLevel = true
+		l.levelRanks = maps.Clone(ranks)
+		if key != "" {
+			l.levelKey = key
+		}
+	})
+}
+
+// TimeKey sets key of the message time, "time" by default.
+func TimeKey(key string) Option {
+	return optionFunc(func(l *Log) {
+		l.timeKey = key
+	})
+}
+
+// NoTime disables automatic time of messages, time is printed only if Time field is added.
+// Use it when log collector stamps messages itself.
+func NoTime() Option {
+	return optionFunc(func(l *Log) {
+		l.noTime = true
+	})
+}
+
+// BytesFormat is an encoding of Bytes fields.
+type BytesFormat int
+
+const (
+	// BytesHex prints bytes as a hex string, like "deadbeef". This is the default.
+	BytesHex BytesFormat = iota
+	// BytesBase64 prints bytes as a standard base64 string.
+	BytesBase64
+)
+
+// BytesEncoding sets encoding of Bytes fields. If maxLen > 0, only first maxLen bytes are printed
+// followed by "...", and full length is printed as key_len field, like {"payload":"dead...","payload_len":1024}.
+// By default bytes are printed as hex and are not truncated.
+func BytesEncoding(format BytesFormat, maxLen int) Option {
+	return optionFunc(func(l *Log) {
+		l.bytesFormat = format
+		l.maxBytesLen = maxLen
+	})
+}
+
+// MaxFieldLen truncates string values of fields longer than n runes to n runes followed by "…(truncated)",
+// so a single huge value doesn't get the whole message rejected by the log collector.
+// Values are truncated after LogValuer and hooks, other types of values are not truncated.
+func MaxFieldLen(n int) Option {
+	return optionFunc(func(l *Log) {
+		l.maxFieldLen = n
+	})
+}
+
+// OversizePolicy says what MaxLineBytes does with lines which are too long.
+type OversizePolicy int
+
+const (
+	// OversizeTrim removes the largest fields until the line fits and lists their keys in "truncated_fields" field.
+	// Msg, level, time and error are kept, the line is dropped if it doesn't fit without other fields.
+	OversizeTrim OversizePolicy = iota
+	// OversizeDrop drops the whole line.
+	OversizeDrop
+)
+
+// MaxLineBytes limits size of encoded lines, including the newline, to n bytes,
+// for log collectors which reject longer lines. Lines which exceed it are handled according to policy.
+// Limit applies to every output separately.
+func MaxLineBytes(n int, policy OversizePolicy) Option {
+	return optionFunc(func(l *Log) {
+		l.maxLineBytes = n
+		l.oversize = policy
+	})
+}
+
+// Humanize prints HumanDuration and HumanBytes fields as human readable strings like "1.5s" and "1.2 MB"
+// instead of numbers. Format console prints them so without the option.
+func Humanize() Option {
+	return optionFunc(func(l *Log) {
+		l.human = true
+	})
+}
+
+// PrettyJSON indents json messages with 2 spaces, so nested objects are readable during local development.
+// Messages span several lines, which most log collectors don't support, don't use it in production.
+func PrettyJSON() Option {
+	return optionFunc(func(l *Log) {
+		l.prettyJSON = true
+	})
+}
+
+// LineTerminator sets string written after every message instead of "\n", like "\r\n" or "" for no terminator,
+// for destinations which frame messages differently. Custom encoders set by WithEncoder
+// are affected only if they end messages with "\n".
+func LineTerminator(s string) Option {
+	return optionFunc(func(l *Log) {
+		l.lineEnd = s
+	})
+}
+
+// Clock sets function returning current time of messages and Dedup windows, time.Now by default.
+// Use it to get deterministic time in tests.
+func Clock(now func() time.Time) Option {
+	return optionFunc(func(l *Log) {
+		l.clock = now
+	})
+}
+
+// Special layouts for TimeFormat.
+const (
+	// TimeFormatUnix prints time as a number of seconds since Unix epoch.
+	TimeFormatUnix = "unix"
+	// TimeFormatUnixMilli prints time as a number of milliseconds since Unix epoch.
+	TimeFormatUnixMilli = "unixmilli"
+)
+
+// TimeFormat sets layout of the message time, see time.Layout, TimeFormatUnix and TimeFormatUnixMilli.
+// By default json uses time.RFC3339Nano, logfmt uses time.RFC3339.
+func TimeFormat(layout string) Option {
+	return optionFunc(func(l *Log) {
+		l.timeFormat = layout
+	})
+}
@@ -0,0 +1,96 @@
+// Package otellog mirrors messages printed with ctxlog to OpenTelemetry logs.
+package otellog
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/kaey/ctxlog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/log"
+)
+
+// Bridge returns option which emits every printed message as a record to logger,
+// so messages are exported by the OpenTelemetry SDK together with traces and metrics.
+// Record body is msg, severity is converted from level and fields become attributes.
+// Context of the message is passed to logger, so records are correlated with the active span.
+// Timestamp of a record is time of the message, so it matches the printed one, see ctxlog.Clock.
+// Records are emitted from a ctxlog.TimeHook, see ctxlog.Hook for details.
+func Bridge(logger log.Logger) ctxlog.Option {
+	return ctxlog.TimeHook(func(ctx context.Context, t time.Time, level, msg string, fields map[string]any) {
+		var r log.Record
+		r.SetTimestamp(t)
+		r.SetSeverity(severity(level))
+		r.SetSeverityText(level)
+		r.SetBody(attribute.StringValue(msg))
+		r.AddAttributes(attributes(fields)...)
+		logger.Emit(ctx, r)
+	})
+}
+
+func severity(level string) log.Severity {
+	switch level {
+	case ctxlog.LevelDebug:
+		return log.SeverityDebug
+	case ctxlog.LevelWarn:
+		return log.SeverityWarn
+	case ctxlog.LevelError:
+		return log.SeverityError
+	case ctxlog.LevelFatal:
+		return log.SeverityFatal
+	default:
+		return log.SeverityInfo
+	}
+}
+
+// attributes converts fields to attributes sorted by key.
+func attributes(fields map[string]any) []attribute.KeyValue {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	attrs := make([]attribute.KeyValue, 0, len(fields))
+	for _, k := range keys {
+		attrs = append(attrs, attribute.KeyValue{Key: attribute.Key(k), Value: value(fields[k])})
+	}
+	return attrs
+}
+
+func value(v any) attribute.Value {
+	switch v := v.(type) {
+	case string:
+		return attribute.StringValue(v)
+	case bool:
+		return attribute.BoolValue(v)
+	case int:
+		return attribute.IntValue(v)
+	case int64:
+		return attribute.Int64Value(v)
+	case float64:
+		return attribute.Float64Value(v)
+	case []string:
+		return attribute.StringSliceValue(v)
+	case []int:
+		return attribute.IntSliceValue(v)
+	case []byte:
+		return attribute.ByteSliceValue(v)
+	case []any:
+		vals := make([]attribute.Value, 0, len(v))
+		for _, v := range v {
+			vals = append(vals, value(v))
+		}
+		return attribute.SliceValue(vals...)
+	case map[string]any:
+		return attribute.MapValue(attributes(v)...)
+	case time.Time:
+		return attribute.StringValue(v.Format(time.RFC3339Nano))
+	case error:
+		return attribute.StringValue(v.Error())
+	default:
+		return attribute.StringValue(fmt.Sprint(v))
+	}
+}
@@ -0,0 +1,74 @@
+package otellog_test
+
+import (
+	"context"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kaey/ctxlog"
+	"github.com/kaey/ctxlog/otellog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/log"
+	"go.opentelemetry.io/otel/log/embedded"
+)
+
+type recorder struct {
+	embedded.Logger
+
+	mu      sync.Mutex
+	records []log.Record
+}
+
+func (r *recorder) Emit(ctx context.Context, record log.Record) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, record.Clone())
+}
+
+func (r *recorder) Enabled(ctx context.Context, param log.EnabledParameters) bool {
+	return true
+}
+
+func TestBridge(t *testing.T) {
+	rec := new(recorder)
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := ctxlog.New(io.Discard, otellog.Bridge(rec), ctxlog.Clock(func() time.Time { return now }))
+	ctx := ctxlog.With(context.Background(), ctxlog.Str("request_id", "abc"))
+
+	l.Print(ctx, "foo", ctxlog.Level(ctxlog.LevelWarn), ctxlog.Int("n", 1), ctxlog.Object("user", ctxlog.Str("name", "x")))
+
+	if len(rec.records) != 1 {
+		t.Fatalf("expected: %v, got: %v", 1, len(rec.records))
+	}
+	r := rec.records[0]
+	if expected, got := "foo", r.Body().AsString(); expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	if expected, got := log.SeverityWarn, r.Severity(); expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	if expected, got := now, r.Timestamp(); !expected.Equal(got) {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	var attrs []attribute.KeyValue
+	r.WalkAttributes(func(kv attribute.KeyValue) bool {
+		attrs = append(attrs, kv)
+		return true
+	})
+	expected := []attribute.KeyValue{
+		attribute.Int64("n", 1),
+		attribute.String("request_id", "abc"),
+		attribute.Map("user", attribute.String("name", "x")),
+	}
+	if len(expected) != len(attrs) {
+		t.Fatalf("expected: %v, got: %v", expected, attrs)
+	}
+	for i := range expected {
+		if expected[i].Key != attrs[i].Key || expected[i].Value.Emit() != attrs[i].Value.Emit() {
+			t.Errorf("expected: %v, got: %v", expected[i], attrs[i])
+		}
+	}
+}
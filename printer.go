@@ -2,91 +2,816 @@ package ctxlog
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"encoding/base64"
 	"errors"
+	"io"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
+// bufPool is the default pool of line buffers, see PoolHints.
 var bufPool sync.Pool = sync.Pool{
 	New: func() any {
 		return new(bytes.Buffer)
 	},
 }
 
-var mapPool sync.Pool = sync.Pool{
+var entryPool sync.Pool = sync.Pool{
 	New: func() any {
-		return make(map[string]any, 10)
+		return &entry{fields: make([]Field, 0, 10), call: make([]Field, 0, 10)}
 	},
 }
 
-func (l *Log) print(cd *ctxdata, msg string) {
-	m := mapPool.Get().(map[string]any)
+// entry is a message with its fields collected and deduplicated.
+type entry struct {
+	msg      string
+	level    string
+	hasLevel bool
+	fields   []Field
+	call     []Field
+	errMsg   string // message of the error field, see dedupKey
+	// Keys of msg, level and time, see KeyPolicy.
+	msgKey   string
+	levelKey string
+	timeKey  string
+	time     time.Time
+	hasTime  bool
+}
+
+func (e *entry) reset() {
+	clear(e.fields)
+	clear(e.call)
+	*e = entry{fields: e.fields[:0], call: e.call[:0]}
+}
+
+// print encodes and writes message msg with fields of the call and fields of cd.
+// calldepth is the number of stack frames to skip to get to the caller, see Log.output.
+// It returns encoding and write errors, see Log.PrintErr.
+func (l *Log) print(ctx context.Context, cd *ctxdata, fields []Field, calldepth int, msg string) error {
+	if l.nop {
+		return nil
+	}
+	if minLevel := l.minLevelFor(ctx); minLevel != 0 && levelRank(l.level(&ctxdata{prev: cd, fields: fields})) < minLevel {
+		return nil
+	}
+
+	if l.sampledOut(ctx, cd, fields) {
+		return nil
+	}
+
+	if l.verbs != nil && hasFormatVerb(msg) {
+		if l.verbs.count(msg) == 1 {
+			l.print(ctx, cd, []Field{Level(LevelWarn), Str("orig_msg", msg)}, calldepth+1,
+				"ctxlog: message contains format verbs, use fields instead")
+		}
+	}
+
+	var dropped uint64
+	if l.sampler != nil {
+		var ok bool
+		if dropped, ok = l.sampler.sample(msg); !ok {
+			return nil
+		}
+	}
+
+	e := l.entPool.Get().(*entry)
 	defer func() {
-		clear(m)
-		mapPool.Put(m)
+		e.reset()
+		l.entPool.Put(e)
 	}()
 
-	handleFields := func(fs []Field) {
-		for _, f := range fs {
+	// Fields of the call are copied to e, so the variadic slice of Print doesn't escape to heap.
+	e.msg = msg
+	e.call = append(e.call, fields...)
+	l.collect(ctx, e, &ctxdata{prev: cd, fields: e.call})
+	l.redact(e)
+	if !l.filter(ctx, e) {
+		return nil
+	}
+
+	if dropped > 0 {
+		e.fields = append(e.fields, Int64("sampled_dropped", int64(dropped)))
+	}
+
+	for _, f := range fields {
+		if f.kind == kindSkip {
+			calldepth += int(f.num)
+		}
+	}
+
+	if l.deduper != nil {
+		repeated, pending, ok := l.deduper.check(dedupKey(e), l.clock(), func() dedupSource {
+			// Fields are copied, as the line is printed later. So is its caller.
+			fs := make([]Field, 0, len(fields)+1)
+			if l.caller && !hasKey(e.fields, "caller") {
+				fs = append(fs, l.callerField(calldepth+2))
+			}
+			return dedupSource{l: l, ctx: ctx, cd: cd, fields: append(fs, fields...), msg: msg}
+		})
+		pending.report()
+		if !ok {
+			return nil
+		}
+		if repeated > 0 {
+			e.fields = append(e.fields, Int64("repeated", int64(repeated)))
+		}
+	}
+
+	if l.seq != nil {
+		e.fields = append(e.fields, Int64("seq", int64(l.seq.Add(1))))
+	}
+	if l.counter != nil {
+		e.fields = append(e.fields, Int64("count", int64(l.counter.count(msg))))
+	}
+
+	if l.caller && !hasKey(e.fields, "caller") {
+		if f := l.callerField(calldepth); f.key != "" {
+			e.fields = append(e.fields, f)
+		}
+	}
+
+	if l.stackOnError && levelRank(e.level) >= levelRank(LevelError) &&
+		!hasKey(e.fields, "error_stack") && !hasKey(e.fields, "ctxlog.error_stack") {
+		pc := make([]uintptr, maxCallerStack)
+		pc = pc[:runtime.Callers(calldepth+1, pc)]
+		e.fields = append(e.fields, Value("error_stack", l.stack(pc)))
+	}
+
+	l.runHooks(ctx, e)
+	if l.maxFieldLen > 0 {
+		l.truncate(e.fields)
+	}
+	if l.sortKeys {
+		sortFields(e.fields)
+	}
+
+	buf := l.bufPool.Get().(*bytes.Buffer)
+	var arr [4][2]int
+	spans, encErr := l.encode(buf, e, arr[:0])
+	if l.levelMetrics != nil {
+		switch {
+		case encErr != nil:
+			l.levelMetrics(LevelError)
+		case e.level == "":
+			l.levelMetrics(LevelInfo)
+		default:
+			l.levelMetrics(e.level)
+		}
+	}
+	if l.batch != nil && l.batch.add(buf, spans, e.level) {
+		return encErr
+	}
+	if l.async != nil && l.async.send(buf, spans, e.level) {
+		return encErr
+	}
+	err := l.write(buf.Bytes(), spans, e.level)
+	buf.Reset()
+	l.bufPool.Put(buf)
+	if encErr != nil {
+		return errors.Join(encErr, err)
+	}
+	return err
+}
+
+// encode appends e to buf once for every distinct encoding of outputs
+// and returns span of buf for every output.
+// Outputs with the same encoding share the span,
+// outputs whose level is above level of the message get span -1, as well as lines dropped by MaxLineBytes.
+// If e can't be encoded for some output, the error line is written instead and the first error is returned.
+func (l *Log) encode(buf *bytes.Buffer, e *entry, spans [][2]int) ([][2]int, error) {
+	var encErr error
+	rank := levelRank(e.level)
+	for i, o := range l.outputs {
+		span := [2]int{-1, -1}
+		if rank < o.minLevel {
+			spans = append(spans, span)
+			continue
+		}
+		for j, prev := range l.outputs[:i] {
+			if spans[j][0] >= 0 && prev.enc == nil && o.enc == nil && prev.format == o.format && prev.color == o.color {
+				span = spans[j]
+				break
+			}
+		}
+		if span[0] < 0 {
+			p, err := l.appendEntry(buf.AvailableBuffer(), e, o)
+			if l.maxLineBytes > 0 && len(p) > l.maxLineBytes {
+				p, err = l.trimEntry(buf.AvailableBuffer(), e, o)
+			}
+			if encErr == nil {
+				encErr = err
+			}
+			if p != nil {
+				span[0] = buf.Len()
+				buf.Write(p)
+				span[1] = buf.Len()
+			}
+		}
+		spans = append(spans, span)
+	}
+	return spans, encErr
+}
+
+// write writes spans of p with message at level to outputs under a single lock.
+// Write errors are passed to OnError and returned joined.
+func (l *Log) write(p []byte, spans [][2]int, level string) error {
+	var errs []error
+	l.mu.Lock()
+	for i, o := range l.outputs {
+		if spans[i][0] >= 0 {
+			if _, err := writeLevel(o.w, level, p[spans[i][0]:spans[i][1]]); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	l.mu.Unlock()
+	return l.writeError(errors.Join(errs...))
+}
+
+// writeBatch writes lines to outputs under a single lock, with one Write call per output,
+// except writers implementing levelWriter, which get one call per line.
+// scratch is used to join lines. Write errors are returned joined.
+func (l *Log) writeBatch(lines []asyncLine, scratch *bytes.Buffer) error {
+	var errs []error
+	l.mu.Lock()
+	for i, o := range l.outputs {
+		_, perLine := o.w.(levelWriter)
+		scratch.Reset()
+		for _, line := range lines {
+			if line.buf == nil || line.spans[i][0] < 0 {
+				continue
+			}
+			p := line.buf.Bytes()[line.spans[i][0]:line.spans[i][1]]
+			if perLine {
+				if _, err := writeLevel(o.w, line.level, p); err != nil {
+					errs = append(errs, err)
+				}
+			} else {
+				scratch.Write(p)
+			}
+		}
+		if scratch.Len() > 0 {
+			if _, err := o.w.Write(scratch.Bytes()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	l.mu.Unlock()
+	return errors.Join(errs...)
+}
+
+// callerField returns "caller" field with file and line of the caller calldepth frames up the stack,
+// or empty field if it is unknown.
+func (l *Log) callerField(calldepth int) Field {
+	_, file, line, ok := runtime.Caller(calldepth + 1)
+	if !ok {
+		return Field{}
+	}
+	return Str("caller", filepath.Base(file)+":"+strconv.Itoa(line))
+}
+
+// writeError passes non-nil err to OnError and returns it. It is called outside of the lock,
+// so the callback can print with the same Log.
+func (l *Log) writeError(err error) error {
+	if err != nil && l.onError != nil {
+		l.onError(err)
+	}
+	return err
+}
+
+// levelWriter is implemented by writers which handle messages differently depending on their level,
+// like SyslogWriter. WriteLevel is called with a single line.
+type levelWriter interface {
+	WriteLevel(level string, p []byte) (int, error)
+}
+
+func writeLevel(w io.Writer, level string, p []byte) (int, error) {
+	if lw, ok := w.(levelWriter); ok {
+		return lw.WriteLevel(level, p)
+	}
+	return w.Write(p)
+}
+
+// output is a writer with its own format, see AddOutput and LevelOutput.
+type output struct {
+	w        io.Writer
+	format   Format
+	enc      Encoder
+	color    bool
+	minLevel int
+	// primary is set if output uses format of the primary writer.
+	primary bool
+}
+
+// appendEntry appends e encoded in format of o to b, terminated as set by LineTerminator.
+// If e can't be encoded, line describing the error is appended instead and the error is returned.
+func (l *Log) appendEntry(b []byte, e *entry, o output) ([]byte, error) {
+	if hasHuman(e.fields) {
+		fs := e.fields
+		e.fields = humanize(fs, l.human || o.enc == nil && o.format == FormatConsole)
+		defer func() { e.fields = fs }()
+	}
+
+	p, err := l.appendLine(b, e, o)
+	if l.lineEnd != "\n" && len(p) > len(b) && p[len(p)-1] == '\n' {
+		p = append(p[:len(p)-1], l.lineEnd...)
+	}
+	return p, err
+}
+
+// appendLine appends e encoded in format of o to b, see appendEntry.
+func (l *Log) appendLine(b []byte, e *entry, o output) ([]byte, error) {
+	if o.enc != nil {
+		p, err := l.appendEncoded(b, e, o.enc)
+		if err != nil {
+			return l.appendJSONError(b, e, err), err
+		}
+		return p, nil
+	}
+
+	switch o.format {
+	case FormatConsole:
+		p, err := l.appendConsole(b, e, o.color)
+		if err != nil {
+			return l.appendLogfmtError(b, e, err), err
+		}
+		return p, nil
+	case FormatLogfmt:
+		p, err := l.appendLogfmt(b, e)
+		if err != nil {
+			return l.appendLogfmtError(b, e, err), err
+		}
+		return p, nil
+	default:
+		p, err := l.appendJSON(b, e)
+		if err != nil {
+			return l.appendJSONError(b, e, err), err
+		}
+		return p, nil
+	}
+}
+
+// collect fills e with fields of the logger, context extractors and the context chain cd.
+//
+// Fields are ordered as they were added: logger fields first, then fields returned by extractors,
+// then context fields from the oldest to the newest, then call fields.
+// If the same key is used several times, the most specific field wins
+// (call fields over context fields over logger fields),
+// within a single call the first one wins, or the last one with LastWins.
+// Values of keys listed in MergeKeys are collected into an array instead.
+//
+// Fields added after WithGroup are nested in an object named after the group,
+// except for error, time, level and caller which are always top level.
+func (l *Log) collect(ctx context.Context, e *entry, cd *ctxdata) {
+	var arr [8]node
+	nodes := arr[:0]
+	for d := cd; d != nil; d = d.prev {
+		nodes = append(nodes, node{fields: d.fields, group: d.group})
+	}
+	for i := len(l.extractors) - 1; i >= 0; i-- {
+		nodes = append(nodes, node{fields: l.extractors[i](ctx)})
+	}
+	nodes = append(nodes, node{fields: l.fields})
+
+	var groups []string
+	for i := len(nodes) - 1; i >= 0; i-- {
+		if nodes[i].group != "" {
+			groups = append(groups, nodes[i].group)
+		}
+		nodes[i].depth = len(groups)
+	}
+
+	shadowed := func(i, j int, key string) bool {
+		same := nodes[i].fields[:j]
+		if l.lastWins {
+			same = nodes[i].fields[j+1:]
+		}
+		if hasKey(same, key) {
+			return true
+		}
+		for _, n := range nodes[:i] {
+			if (n.depth == nodes[i].depth || isMetaKey(key)) && hasKey(n.fields, key) {
+				return true
+			}
+		}
+		return false
+	}
+	defined := func(key string) bool {
+		for _, n := range nodes {
+			if n.depth == 0 && hasKey(n.fields, key) {
+				return true
+			}
+		}
+		return false
+	}
+
+	var nested [][]Field
+	if len(groups) > 0 {
+		nested = make([][]Field, len(groups)+1)
+	}
+	var add func(depth int, f Field)
+	add = func(depth int, f Field) {
+		f = f.resolve()
+		if f.kind == kindObject {
+			f.val = l.object(f.val.([]Field))
+		}
+		if depth == 0 && l.keyPolicy == KeyPolicyRename && l.reservedKey(f.key) {
+			f.key = "fields." + f.key
+		}
+		var size Field
+		if f.kind == kindBytes {
+			f, size = l.bytes(f)
+		}
+		if depth == 0 {
+			e.fields = append(e.fields, f)
+		} else {
+			nested[depth] = append(nested[depth], f)
+		}
+		if size.key != "" {
+			add(depth, size)
+		}
+	}
+
+	// merged returns values of all fields with key at depth of node i, from the oldest to the newest.
+	// Errors are replaced with their messages.
+	// It returns false if such field was seen before field j of node i.
+	merged := func(i, j int, key string) ([]any, bool) {
+		if hasKey(nodes[i].fields[:j], key) {
+			return nil, false
+		}
+		var vals []any
+		for k := len(nodes) - 1; k >= 0; k-- {
+			if nodes[k].depth != nodes[i].depth {
+				continue
+			}
+			for _, f := range nodes[k].fields {
+				if f.key != key {
+					continue
+				}
+				if k > i {
+					return nil, false
+				}
+				v := f.resolve().value()
+				if err, ok := v.(error); ok {
+					v = err.Error()
+				}
+				vals = append(vals, v)
+			}
+		}
+		return vals, true
+	}
+
+	for i := len(nodes) - 1; i >= 0; i-- {
+		for j, f := range nodes[i].fields {
 			if f.key == "" {
 				continue
 			}
-			if _, exists := m[f.key]; exists {
+			if !isMetaKey(f.key) && l.mergeKey(f.key) {
+				if vals, ok := merged(i, j, f.key); ok {
+					add(nodes[i].depth, Value(f.key, vals))
+				}
+				continue
+			}
+			if shadowed(i, j, f.key) {
 				continue
 			}
+			depth := nodes[i].depth
+			if isMetaKey(f.key) {
+				depth = 0
+			}
 
 			switch f.key {
 			case "error":
 				err, ok := f.val.(error)
-				if ok {
-					m["error"] = err.Error()
+				if !ok {
+					continue
+				}
+				e.errMsg = err.Error()
+				if l.marshalErr != nil {
+					e.fields = append(e.fields, Value("error", l.marshalErr(err)))
+				} else {
+					e.fields = append(e.fields, Str("error", e.errMsg))
 				}
 
-				var st Stacker
-				if errors.As(err, &st) {
-					m["error_stack"] = stack(st)
+				if chain := errorChain(err); chain != nil {
+					if key, ok := l.ownKey("error_chain", defined("error_chain")); ok {
+						e.fields = append(e.fields, Value(key, chain))
+					}
+				}
+
+				if st, ok := findStacker(err); ok {
+					if key, ok := l.ownKey("error_stack", defined("error_stack")); ok {
+						e.fields = append(e.fields, Value(key, l.cachedStack(st.Stack())))
+					}
 				}
 			case "time":
+				// Time of another type is ignored, current time is used instead,
+				// unless KeyPolicy says otherwise.
 				t, ok := f.val.(time.Time)
 				if ok {
-					m["time"] = t.UTC()
+					e.time, e.hasTime = t.UTC(), true
+				} else if l.keyPolicy != KeyPolicyIgnore {
+					add(depth, f)
+				}
+			case "level":
+				lvl, ok := f.string()
+				if ok {
+					e.level, e.hasLevel = lvl, true
+				} else {
+					add(depth, f)
 				}
 			default:
-				m[f.key] = f.val
+				add(depth, f)
 			}
 		}
 	}
 
-	for d := cd; d != nil; d = d.prev {
-		handleFields(d.fields)
+	for depth := len(groups); depth > 0; depth-- {
+		if len(nested[depth]) > 0 {
+			add(depth-1, Field{key: groups[depth-1], kind: kindObject, val: nested[depth]})
+		}
 	}
-	handleFields(l.fields)
 
-	m["msg"] = msg
-	if _, ok := m["time"].(time.Time); !ok {
-		m["time"] = time.Now().UTC()
+	if !e.hasTime && !l.noTime {
+		e.time, e.hasTime = l.clock().UTC(), true
 	}
 
-	buf := bufPool.Get().(*bytes.Buffer)
-	defer func() {
-		buf.Reset()
-		bufPool.Put(buf)
-	}()
+	e.msgKey, e.levelKey, e.timeKey = l.msgKey, l.levelKey, l.timeKey
+	if l.keyPolicy == KeyPolicyPrefix {
+		e.msgKey, _ = l.ownKey(e.msgKey, hasKey(e.fields, e.msgKey))
+		e.levelKey, _ = l.ownKey(e.levelKey, hasKey(e.fields, e.levelKey))
+		e.timeKey, _ = l.ownKey(e.timeKey, hasKey(e.fields, e.timeKey))
+	}
+	if l.omitMsg && e.msg == "" {
+		e.msgKey = ""
+	}
+	if l.keyPolicy == KeyPolicyIgnore {
+		// Keys of Log win, so a field can't replace the message in decoders which keep the last key.
+		e.fields = slices.DeleteFunc(e.fields, func(f Field) bool {
+			return f.key == e.msgKey && e.msgKey != "" || f.key == e.levelKey && e.hasLevel || f.key == e.timeKey && e.hasTime
+		})
+	}
+}
 
-	if err := json.NewEncoder(buf).Encode(m); err != nil {
-		t := m["time"].(time.Time)
-		encErr := map[string]string{
-			"time":     t.Format(time.RFC3339),
-			"error":    err.Error(),
-			"msg":      "ctxlog: json encode error",
-			"orig_msg": msg,
+// object returns copy of fields of an object with repeated and empty keys removed and values resolved,
+// so fields passed to Object can be redacted and sorted without modifying the caller's slice.
+func (l *Log) object(fs []Field) []Field {
+	obj := make([]Field, 0, len(fs))
+	for i, f := range fs {
+		if f.key == "" {
+			continue
 		}
-		buf.Reset()
-		if err := json.NewEncoder(buf).Encode(encErr); err != nil {
-			panic(err)
+		same := fs[:i]
+		if l.lastWins {
+			same = fs[i+1:]
 		}
+		if hasKey(same, f.key) {
+			continue
+		}
+		f = f.resolve()
+		switch f.kind {
+		case kindObject:
+			f.val = l.object(f.val.([]Field))
+		case kindBytes:
+			var size Field
+			if f, size = l.bytes(f); size.key != "" {
+				obj = append(obj, f, size)
+				continue
+			}
+		}
+		obj = append(obj, f)
+	}
+	return obj
+}
+
+// bytes returns Bytes field f encoded as a string as set by BytesEncoding.
+// If f is truncated, field with its full length is returned as well.
+func (l *Log) bytes(f Field) (Field, Field) {
+	b := f.val.([]byte)
+	var size Field
+	if l.maxBytesLen > 0 && len(b) > l.maxBytesLen {
+		size = Int(f.key+"_len", len(b))
+		b = b[:l.maxBytesLen]
 	}
 
-	buf.WriteTo(l.w)
+	var p []byte
+	switch l.bytesFormat {
+	case BytesBase64:
+		p = make([]byte, base64.StdEncoding.EncodedLen(len(b)), base64.StdEncoding.EncodedLen(len(b))+3)
+		base64.StdEncoding.Encode(p, b)
+	default:
+		p = make([]byte, 0, len(b)*2+3)
+		for _, c := range b {
+			p = append(p, hex[c>>4], hex[c&0xF])
+		}
+	}
+	if size.key != "" {
+		p = append(p, "..."...)
+	}
+	return Str(f.key, string(p)), size
+}
+
+// hasHuman reports whether fs contain HumanDuration or HumanBytes fields, including nested ones.
+func hasHuman(fs []Field) bool {
+	for _, f := range fs {
+		switch f.kind {
+		case kindHumanDuration, kindHumanBytes:
+			return true
+		case kindObject:
+			if hasHuman(f.val.([]Field)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// humanize returns copy of fs with HumanDuration and HumanBytes fields converted to human readable strings
+// if human is set, to numbers otherwise. It is called for every output, as they may have different formats:
+// Humanize applies to all of them, format console is always human readable.
+func humanize(fs []Field, human bool) []Field {
+	hfs := make([]Field, len(fs))
+	for i, f := range fs {
+		switch {
+		case f.kind == kindHumanDuration && human:
+			f = Str(f.key, time.Duration(f.num).String())
+		case f.kind == kindHumanBytes && human:
+			f = Str(f.key, humanBytes(f.num))
+		case f.kind == kindHumanDuration || f.kind == kindHumanBytes:
+			f = Int64(f.key, f.num)
+		case f.kind == kindObject && hasHuman(f.val.([]Field)):
+			f.val = humanize(f.val.([]Field), human)
+		}
+		hfs[i] = f
+	}
+	return hfs
+}
+
+// humanBytes formats n like "512 B" or "1.2 MB", with decimal units.
+func humanBytes(n int64) string {
+	const units = "kMGTPE"
+	if n < 1000 && n > -1000 {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	v := float64(n)
+	i := -1
+	for (v >= 999.95 || v <= -999.95) && i < len(units)-1 {
+		v /= 1000
+		i++
+	}
+	return strconv.FormatFloat(v, 'f', 1, 64) + " " + units[i:i+1] + "B"
+}
+
+// reservedKey reports whether key is used by fields printed by Log itself.
+func (l *Log) reservedKey(key string) bool {
+	switch key {
+	case "level", "error_chain", "error_stack", l.msgKey, l.levelKey, l.timeKey:
+		return true
+	default:
+		return false
+	}
+}
+
+// ownKey returns key of a field printed by Log itself.
+// If taken is set, there is a field with the same key and key is resolved according to KeyPolicy:
+// with KeyPolicyIgnore it returns false, with KeyPolicyPrefix key is prefixed with "ctxlog.".
+func (l *Log) ownKey(key string, taken bool) (string, bool) {
+	if !taken {
+		return key, true
+	}
+	switch l.keyPolicy {
+	case KeyPolicyIgnore:
+		return key, false
+	case KeyPolicyPrefix:
+		return "ctxlog." + key, true
+	default:
+		return key, true
+	}
+}
+
+// sortFields sorts fs and fields of objects in fs by key.
+func sortFields(fs []Field) {
+	slices.SortStableFunc(fs, func(a, b Field) int {
+		return strings.Compare(a.key, b.key)
+	})
+	for _, f := range fs {
+		if f.kind == kindObject {
+			sortFields(f.val.([]Field))
+		}
+	}
+}
+
+// compareKey compares key of f with key, for binary search in fields sorted by SortKeys.
+func compareKey(f Field, key string) int {
+	return strings.Compare(f.key, key)
+}
+
+// Keys printed by Log itself, see ownKeys.
+const (
+	keyMsg = iota
+	keyLevel
+	keyTime
+)
+
+// ownKey is msg, level or time key of an entry, printed before field at of the entry.
+type ownKey struct {
+	kind int
+	key  string
+	at   int
+}
+
+// ownKeys returns msg, level and time keys of e in order they are printed, n is the number of them.
+// Msg and level are printed before fields and time after them,
+// unless SortAllKeys is set, then all keys are printed in order.
+func (l *Log) ownKeys(e *entry) (keys [3]ownKey, n int) {
+	if e.msgKey != "" {
+		keys[n] = ownKey{kind: keyMsg, key: e.msgKey}
+		n++
+	}
+	if e.hasLevel {
+		keys[n] = ownKey{kind: keyLevel, key: e.levelKey}
+		n++
+	}
+	if e.hasTime {
+		keys[n] = ownKey{kind: keyTime, key: e.timeKey, at: len(e.fields)}
+		n++
+	}
+	if l.sortAll {
+		slices.SortStableFunc(keys[:n], func(a, b ownKey) int {
+			return strings.Compare(a.key, b.key)
+		})
+		for i := range keys[:n] {
+			keys[i].at, _ = slices.BinarySearchFunc(e.fields, keys[i].key, compareKey)
+		}
+	}
+	return keys, n
+}
+
+// mergeKey reports whether values of key are merged, see MergeKeys.
+func (l *Log) mergeKey(key string) bool {
+	for _, k := range l.mergeKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// node is a set of fields added by a single New, With or Print call.
+type node struct {
+	fields []Field
+	group  string
+	depth  int
+}
+
+// fmtVerbs are letters of fmt verbs.
+const fmtVerbs = "vTtbcdoOqxXUeEfFgGspw"
+
+// hasFormatVerb reports whether s contains fmt verb like %s, %-5d or %+v, but not %% or "100% done".
+// Percent signs following a letter or a digit, like in "a%20b" or "100%full", are not considered verbs.
+func hasFormatVerb(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '%' {
+			i++
+			continue
+		}
+		if i > 0 && isAlnum(s[i-1]) {
+			continue
+		}
+		j := i + 1
+		for j < len(s) && strings.IndexByte("+-#0123456789.", s[j]) >= 0 {
+			j++
+		}
+		if j < len(s) && strings.IndexByte(fmtVerbs, s[j]) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func isAlnum(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// isMetaKey reports whether key describes the message itself and is never nested in a group.
+func isMetaKey(key string) bool {
+	return key == "error" || key == "time" || key == "level" || key == "caller"
+}
+
+func hasKey(fs []Field, key string) bool {
+	for _, f := range fs {
+		if f.key == key {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,50 @@
+package ctxlog
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// Recover recovers panic, prints it at error level with "panic" and "error_stack" fields
+// and panics again with the same value. Use it as defer log.Recover(ctx).
+// Stack starts at the place of the panic.
+func (l *Log) Recover(ctx context.Context) {
+	if r := recover(); r != nil {
+		l.printPanic(ctx, r)
+		panic(r)
+	}
+}
+
+// RecoverSwallow is Recover which doesn't panic again, so the goroutine continues after the deferred call.
+func (l *Log) RecoverSwallow(ctx context.Context) {
+	if r := recover(); r != nil {
+		l.printPanic(ctx, r)
+	}
+}
+
+func (l *Log) printPanic(ctx context.Context, r any) {
+	if l == nil {
+		return
+	}
+
+	pc := make([]uintptr, maxCallerStack)
+	pc = pc[:runtime.Callers(3, pc)]
+	// Caller is the place of the panic, the first frame after runtime.gopanic.
+	stack := panicPC(pc)
+	l.output(ctx, 3+len(pc)-len(stack), "panic", []Field{
+		Level(LevelError),
+		Str("panic", fmt.Sprint(r)),
+		Value("error_stack", l.stack(stack)),
+	})
+}
+
+// panicPC returns frames of pc after runtime.gopanic, which are frames of the panicking goroutine.
+func panicPC(pc []uintptr) []uintptr {
+	for i, p := range pc {
+		if fn := runtime.FuncForPC(p - 1); fn != nil && fn.Name() == "runtime.gopanic" {
+			return pc[i+1:]
+		}
+	}
+	return pc
+}
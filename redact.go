@@ -0,0 +1,43 @@
+package ctxlog
+
+import "strings"
+
+const redacted = "[REDACTED]"
+
+// redact replaces values of fields configured with Redact and RedactFunc.
+func (l *Log) redact(e *entry) {
+	if len(l.redactKeys) == 0 && len(l.redactFuncs) == 0 {
+		return
+	}
+	l.redactFields(e.fields)
+}
+
+// redactFields redacts fs in place. Objects, including groups, are redacted as a whole if their own key
+// matches, otherwise their fields are redacted one by one.
+func (l *Log) redactFields(fs []Field) {
+fields:
+	for i, f := range fs {
+		if l.redactKey(f.key) {
+			fs[i] = Str(f.key, redacted)
+			continue
+		}
+		for _, fn := range l.redactFuncs {
+			if v, ok := fn(f.key, f.value()); ok {
+				fs[i] = Value(f.key, v)
+				continue fields
+			}
+		}
+		if f.kind == kindObject {
+			l.redactFields(f.val.([]Field))
+		}
+	}
+}
+
+func (l *Log) redactKey(key string) bool {
+	for _, k := range l.redactKeys {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
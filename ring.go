@@ -0,0 +1,67 @@
+package ctxlog
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Ring keeps the most recent lines written to it in memory, see RingWriter.
+// It is safe for concurrent use.
+type Ring struct {
+	mu    sync.Mutex
+	lines [][]byte
+	next  int
+	full  bool
+}
+
+// RingWriter returns writer which keeps the last capacity lines in memory, for example to show recent messages
+// on a debug page. Use it with AddOutput or LevelOutput in addition to the main writer.
+func RingWriter(capacity int) *Ring {
+	return &Ring{lines: make([][]byte, max(capacity, 1))}
+}
+
+// Write stores lines of p, partial last line is stored as a whole line.
+func (r *Ring) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := len(p)
+	for len(p) > 0 {
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			line = p[:i+1]
+		}
+		p = p[len(line):]
+
+		// Buffers of overwritten lines are reused.
+		r.lines[r.next] = append(r.lines[r.next][:0], line...)
+		r.next++
+		if r.next == len(r.lines) {
+			r.next = 0
+			r.full = true
+		}
+	}
+	return n, nil
+}
+
+// Lines returns copies of stored lines from the oldest to the newest, with trailing newlines.
+func (r *Ring) Lines() [][]byte {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var lines [][]byte
+	if r.full {
+		lines = make([][]byte, 0, len(r.lines))
+		lines = appendCopies(lines, r.lines[r.next:])
+	} else {
+		lines = make([][]byte, 0, r.next)
+	}
+	return appendCopies(lines, r.lines[:r.next])
+}
+
+func appendCopies(dst, lines [][]byte) [][]byte {
+	for _, line := range lines {
+		dst = append(dst, bytes.Clone(line))
+	}
+	return dst
+}
@@ -0,0 +1,48 @@
+package ctxlog_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/kaey/ctxlog"
+)
+
+func TestRingWriter(t *testing.T) {
+	ring := ctxlog.RingWriter(2)
+	log := ctxlog.New(io.Discard, ctxlog.LevelOutput(ctxlog.LevelWarn, ring), ctxlog.NoTime())
+	ctx := context.Background()
+
+	log.Print(ctx, "a", ctxlog.Level(ctxlog.LevelWarn))
+	expected := `[{"msg":"a","level":"warn"}` + "\n]"
+	got := fmt.Sprintf("%s", ring.Lines())
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	log.Print(ctx, "b", ctxlog.Level(ctxlog.LevelError))
+	log.Print(ctx, "c")
+	log.Print(ctx, "d", ctxlog.Level(ctxlog.LevelWarn))
+	expected = `{"msg":"b","level":"error"}` + "\n" + `{"msg":"d","level":"warn"}` + "\n"
+	got = string(bytes.Join(ring.Lines(), nil))
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	ring.Write([]byte("e\nf\n"))
+	lines := ring.Lines()
+	got = string(bytes.Join(lines, nil))
+	expected = "e\nf\n"
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	// Lines are copies.
+	lines[0][0] = 'x'
+	got = string(bytes.Join(ring.Lines(), nil))
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
@@ -0,0 +1,305 @@
+package ctxlog
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// FS is a file system used by RotatingWriter, see RotatingFileFS.
+// Paths are passed as they are, like to functions of package os.
+type FS interface {
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Rename(oldpath, newpath string) error
+	Remove(name string) error
+}
+
+// File is a file opened by FS, *os.File implements it.
+type File interface {
+	io.ReadWriteCloser
+	Stat() (os.FileInfo, error)
+	Sync() error
+}
+
+// osFS is FS of the operating system.
+type osFS struct{}
+
+func (osFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (osFS) Rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
+func (osFS) Remove(name string) error             { return os.Remove(name) }
+
+// RotatingWriter is a file which is rotated when it grows above the size limit, see RotatingFile.
+// It is safe for concurrent use.
+type RotatingWriter struct {
+	fs         FS
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu    sync.Mutex
+	f     File
+	size  int64
+	sig   chan os.Signal
+	seq   int   // number of the last rotation, see rotate
+	retry int64 // size at which failed rotation is retried, see Write
+
+	// Rotated files are compressed in the background one by one, in order of rotation,
+	// last is closed when the last started compression is done.
+	// Errors of compression and of rotations made by Write are kept in cerr until they are returned by Sync or Close.
+	last chan struct{}
+	wg   sync.WaitGroup
+	cmu  sync.Mutex
+	cerr error
+}
+
+// RotatingFile opens file at path for appending and returns writer which rotates it
+// when it grows above maxSizeMB megabytes, use it as a writer of New.
+// Rotated files are compressed and renamed to path.1.gz, path.2.gz and so on, the newest first,
+// up to maxBackups files are kept. If maxSizeMB is 0, file is rotated only by Rotate.
+// Compression happens in the background, so it doesn't block writes, its errors are returned by Sync and Close.
+// If rotation fails, writing continues to the same file and rotation is retried after another maxSizeMB,
+// its errors are returned by Sync and Close as well.
+func RotatingFile(path string, maxSizeMB int, maxBackups int) (*RotatingWriter, error) {
+	return RotatingFileFS(osFS{}, path, maxSizeMB, maxBackups)
+}
+
+// RotatingFileFS is RotatingFile which works with files of fsys instead of the operating system,
+// for example to test rotation with a file system in memory.
+func RotatingFileFS(fsys FS, path string, maxSizeMB int, maxBackups int) (*RotatingWriter, error) {
+	w := &RotatingWriter{
+		fs:         fsys,
+		path:       path,
+		maxSize:    int64(maxSizeMB) << 20,
+		maxBackups: maxBackups,
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *RotatingWriter) open() error {
+	f, err := w.fs.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.f, w.size = f, fi.Size()
+	return nil
+}
+
+// Write writes p to the file, rotating it first if p doesn't fit into the size limit.
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return 0, os.ErrClosed
+	}
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > max(w.maxSize, w.retry) {
+		if err := w.rotate(); err != nil {
+			if w.f == nil {
+				return 0, err
+			}
+			w.addErr(err)
+			w.retry = w.size + w.maxSize
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate starts a new file, the current one is compressed to a backup in the background.
+func (w *RotatingWriter) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return os.ErrClosed
+	}
+	return w.rotate()
+}
+
+// rotate renames the file so it is compressed in the background and opens a new one.
+// If the file can't be renamed, it is opened again, so writing continues to the same file.
+// w.f is nil after rotate only if the file can't be opened.
+func (w *RotatingWriter) rotate() error {
+	err := w.f.Close()
+	w.f = nil
+	if err != nil {
+		return errors.Join(err, w.open())
+	}
+
+	if w.maxBackups <= 0 {
+		err := w.fs.Remove(w.path)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return errors.Join(err, w.open())
+	}
+
+	w.seq++
+	rotated := fmt.Sprintf("%s.%d.rotated", w.path, w.seq)
+	if err := w.fs.Rename(w.path, rotated); err != nil {
+		return errors.Join(err, w.open())
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.retry = 0
+
+	prev, done := w.last, make(chan struct{})
+	w.last = done
+	w.wg.Add(1)
+	go w.compress(rotated, prev, done)
+	return nil
+}
+
+// compress shifts backups and compresses rotated file to the first one, after the previous compression is done.
+// If compression fails, rotated file is kept.
+func (w *RotatingWriter) compress(rotated string, prev, done chan struct{}) {
+	defer w.wg.Done()
+	defer close(done)
+	if prev != nil {
+		<-prev
+	}
+
+	var errs []error
+	if err := w.fs.Remove(w.backup(w.maxBackups)); err != nil && !os.IsNotExist(err) {
+		errs = append(errs, err)
+	}
+	for i := w.maxBackups - 1; i > 0; i-- {
+		if err := w.fs.Rename(w.backup(i), w.backup(i+1)); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	if err := compressFile(w.fs, rotated, w.backup(1)); err != nil {
+		errs = append(errs, err)
+	} else if err := w.fs.Remove(rotated); err != nil {
+		errs = append(errs, err)
+	}
+
+	w.addErr(errs...)
+}
+
+// addErr keeps errs until they are returned by compressErr.
+func (w *RotatingWriter) addErr(errs ...error) {
+	w.cmu.Lock()
+	w.cerr = errors.Join(append([]error{w.cerr}, errs...)...)
+	w.cmu.Unlock()
+}
+
+// compressErr waits for compression of rotated files and returns its errors since the last call,
+// together with errors of rotations made by Write.
+func (w *RotatingWriter) compressErr() error {
+	w.wg.Wait()
+	w.cmu.Lock()
+	defer w.cmu.Unlock()
+	err := w.cerr
+	w.cerr = nil
+	return err
+}
+
+func (w *RotatingWriter) backup(i int) string {
+	return fmt.Sprintf("%s.%d.gz", w.path, i)
+}
+
+// compressFile writes src compressed with gzip to dst.
+func compressFile(fsys FS, src, dst string) error {
+	in, err := fsys.OpenFile(src, os.O_RDONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := fsys.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// Reopen closes and opens the file again, use it after the file was moved by an external tool like logrotate.
+func (w *RotatingWriter) Reopen() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return os.ErrClosed
+	}
+	err := w.f.Close()
+	w.f = nil
+	return errors.Join(err, w.open())
+}
+
+// ReopenOnSignal calls Reopen every time one of signals is received,
+// like ReopenOnSignal(syscall.SIGHUP). It stops when w is closed.
+func (w *RotatingWriter) ReopenOnSignal(signals ...os.Signal) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.sig != nil || w.f == nil {
+		return
+	}
+	w.sig = make(chan os.Signal, 1)
+	signal.Notify(w.sig, signals...)
+	go func(sig chan os.Signal) {
+		for range sig {
+			w.Reopen()
+		}
+	}(w.sig)
+}
+
+// Sync commits written data to disk. It waits for compression of rotated files and returns its errors.
+func (w *RotatingWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.f == nil {
+		return os.ErrClosed
+	}
+	return errors.Join(w.f.Sync(), w.compressErr())
+}
+
+// Close closes the file. It waits for compression of rotated files and returns its errors.
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.sig != nil {
+		signal.Stop(w.sig)
+		close(w.sig)
+		w.sig = nil
+	}
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return errors.Join(err, w.compressErr())
+}
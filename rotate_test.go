@@ -0,0 +1,292 @@
+package ctxlog_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kaey/ctxlog"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := ctxlog.RotatingFile(path, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	log := ctxlog.New(w, ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	big := strings.Repeat("x", 600<<10)
+	log.Print(ctx, "1"+big)
+	log.Print(ctx, "2"+big) // Rotates, 1 goes to app.log.1.gz.
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	log.Print(ctx, "3")
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	log.Print(ctx, "4")
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{
+		path:           "msg=4\n",
+		path + ".1.gz": "msg=3\n",
+		path + ".2.gz": "msg=2" + big + "\n",
+	} {
+		got := readLog(t, name)
+		if expected != got {
+			t.Errorf("%s expected: %.20q, got: %.20q", name, expected, got)
+		}
+	}
+	if _, err := os.Stat(path + ".3.gz"); !os.IsNotExist(err) {
+		t.Errorf("expected: %v, got: %v", "no backup 3", err)
+	}
+}
+
+func readLog(t *testing.T, name string) string {
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(name, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = zr
+	}
+	p, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(p)
+}
+
+// memFS is ctxlog.FS in memory.
+type memFS struct {
+	mu    sync.Mutex
+	files map[string]*bytes.Buffer
+	// Renames of failRename fail, opening of blockOpen waits until block is closed.
+	failRename string
+	blockOpen  string
+	block      chan struct{}
+}
+
+func (m *memFS) OpenFile(name string, flag int, perm os.FileMode) (ctxlog.File, error) {
+	if name == m.blockOpen {
+		<-m.block
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	b, ok := m.files[name]
+	if !ok {
+		if flag&os.O_CREATE == 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		b = new(bytes.Buffer)
+		m.files[name] = b
+	}
+	if flag&os.O_TRUNC != 0 {
+		b.Reset()
+	}
+	return &memFile{name: name, buf: b, r: bytes.NewReader(b.Bytes())}, nil
+}
+
+func (m *memFS) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if oldpath == m.failRename {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrPermission}
+	}
+	b, ok := m.files[oldpath]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrNotExist}
+	}
+	delete(m.files, oldpath)
+	m.files[newpath] = b
+	return nil
+}
+
+func (m *memFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(m.files, name)
+	return nil
+}
+
+type memFile struct {
+	name string
+	buf  *bytes.Buffer
+	r    *bytes.Reader
+}
+
+func (f *memFile) Read(p []byte) (int, error)  { return f.r.Read(p) }
+func (f *memFile) Write(p []byte) (int, error) { return f.buf.Write(p) }
+func (f *memFile) Close() error                { return nil }
+func (f *memFile) Sync() error                 { return nil }
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	return memFileInfo{name: f.name, size: int64(f.buf.Len())}, nil
+}
+
+type memFileInfo struct {
+	name string
+	size int64
+}
+
+func (fi memFileInfo) Name() string       { return filepath.Base(fi.name) }
+func (fi memFileInfo) Size() int64        { return fi.size }
+func (fi memFileInfo) Mode() os.FileMode  { return 0o644 }
+func (fi memFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi memFileInfo) IsDir() bool        { return false }
+func (fi memFileInfo) Sys() any           { return nil }
+
+func TestRotatingFileFS(t *testing.T) {
+	fsys := &memFS{files: map[string]*bytes.Buffer{"app.log": bytes.NewBufferString("msg=0\n")}}
+	w, err := ctxlog.RotatingFileFS(fsys, "app.log", 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	log := ctxlog.New(w, ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	log.Print(ctx, "1")
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	log.Print(ctx, "2")
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	log.Print(ctx, "3")
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := 2, len(fsys.files); expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	if expected, got := "msg=3\n", fsys.files["app.log"].String(); expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	zr, err := gzip.NewReader(fsys.files["app.log.1.gz"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := "msg=2\n", string(p); expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestRotatingFileBackground(t *testing.T) {
+	fsys := &memFS{files: map[string]*bytes.Buffer{}, blockOpen: "app.log.1.gz", block: make(chan struct{})}
+	w, err := ctxlog.RotatingFileFS(fsys, "app.log", 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := ctxlog.New(w, ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	// Writes are not blocked while the rotated file is compressed.
+	log.Print(ctx, "1")
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	log.Print(ctx, "2")
+	close(fsys.block)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected, got := "msg=2\n", fsys.files["app.log"].String(); expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	if _, ok := fsys.files["app.log.1.gz"]; !ok {
+		t.Errorf("expected: %v, got: %v", "app.log.1.gz", fsys.files)
+	}
+}
+
+func TestRotatingFileRenameError(t *testing.T) {
+	fsys := &memFS{files: map[string]*bytes.Buffer{}, failRename: "app.log"}
+	w, err := ctxlog.RotatingFileFS(fsys, "app.log", 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	log := ctxlog.New(w, ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	// The file can't be renamed, writing continues to the same file.
+	log.Print(ctx, "1")
+	if err := w.Rotate(); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected: %v, got: %v", fs.ErrPermission, err)
+	}
+	log.Print(ctx, "2")
+
+	if expected, got := "msg=1\nmsg=2\n", fsys.files["app.log"].String(); expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+
+	// Lines which don't fit are written as well, the error is returned by Sync
+	// and rotation is retried after another maxSizeMB.
+	fsys = &memFS{files: map[string]*bytes.Buffer{}, failRename: "app.log"}
+	w, err = ctxlog.RotatingFileFS(fsys, "app.log", 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	big := []byte(strings.Repeat("x", 600<<10))
+	for _, p := range [][]byte{big, big, []byte("x\n"), []byte("x\n")} {
+		if n, err := w.Write(p); n != len(p) || err != nil {
+			t.Errorf("expected: %v, got: %v %v", len(p), n, err)
+		}
+	}
+	if expected, got := 2*len(big)+4, fsys.files["app.log"].Len(); expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+	if err := w.Sync(); !errors.Is(err, fs.ErrPermission) || strings.Count(err.Error(), "rename") != 1 {
+		t.Errorf("expected: %v, got: %v", fs.ErrPermission, err)
+	}
+
+	// Errors of renaming backups in the background are returned by Sync.
+	fsys = &memFS{files: map[string]*bytes.Buffer{"app.log.1.gz": new(bytes.Buffer)}, failRename: "app.log.1.gz"}
+	w, err = ctxlog.RotatingFileFS(fsys, "app.log", 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Sync(); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("expected: %v, got: %v", fs.ErrPermission, err)
+	}
+}
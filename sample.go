@@ -0,0 +1,39 @@
+package ctxlog
+
+import "context"
+
+// sampler passes 1 of every n messages with the same msg.
+// Up to maxCountKeys messages are counted, least recently printed ones are forgotten first.
+type sampler struct {
+	n    uint64
+	msgs *msgCounter
+}
+
+// sample reports whether message msg should be printed.
+// If it should, number of messages dropped since the last printed one is returned as well.
+func (s *sampler) sample(msg string) (dropped uint64, ok bool) {
+	return s.msgs.sample(msg, s.n)
+}
+
+type samplekeytype struct{}
+
+var samplekey = samplekeytype{}
+
+// WithSampling returns new context with sampling decision of a request, for example made together with
+// the decision to sample its trace. If keep is false, messages printed with the context are dropped,
+// except messages at error level and above, see SampledOutErrors.
+func WithSampling(ctx context.Context, keep bool) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, samplekey, keep)
+}
+
+// sampledOut reports whether message should be dropped because ctx was sampled out, see WithSampling.
+func (l *Log) sampledOut(ctx context.Context, cd *ctxdata, fields []Field) bool {
+	keep, ok := ctx.Value(samplekey).(bool)
+	if !ok || keep {
+		return false
+	}
+	return l.dropErrs || levelRank(l.level(&ctxdata{prev: cd, fields: fields})) < levelRank(LevelError)
+}
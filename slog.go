@@ -0,0 +1,152 @@
+package ctxlog
+
+import (
+	"context"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"strconv"
+)
+
+// Handler is a slog.Handler which prints records with Log.
+// Fields stored in context by With are printed as well.
+type Handler struct {
+	l     *Log
+	nodes []ctxdata
+}
+
+// SlogHandler returns slog.Handler printing with l, use it as slog.New(log.SlogHandler()).
+func (l *Log) SlogHandler() *Handler {
+	return &Handler{l: l}
+}
+
+// Enabled reports whether records with level are printed, see MinLevel.
+func (h *Handler) Enabled(ctx context.Context, level slog.Level) bool {
+	if h.l == nil || h.l.nop {
+		return false
+	}
+	minLevel := h.l.minLevelFor(ctx)
+	return minLevel == 0 || levelRank(slogLevel(level)) >= minLevel
+}
+
+// Handle prints r. Attributes added with WithAttrs and WithGroup are printed after fields stored in ctx.
+// Record with zero time is printed without time.
+// Errors of encoding and writing r are returned, see Log.PrintErr.
+func (h *Handler) Handle(ctx context.Context, r slog.Record) error {
+	if h.l == nil {
+		return nil
+	}
+
+	l := h.l
+	fields := make([]Field, 0, r.NumAttrs()+3)
+	fields = append(fields, Level(slogLevel(r.Level)))
+	if !r.Time.IsZero() {
+		fields = append(fields, Time(r.Time))
+	} else if !l.noTime {
+		// Record without time is printed without time, as slog.Handler requires.
+		n := *l
+		n.noTime = true
+		l = &n
+	}
+	if l.caller && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		fields = append(fields, Str("caller", filepath.Base(frame.File)+":"+strconv.Itoa(frame.Line)))
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		fields = appendAttr(fields, a)
+		return true
+	})
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cd, _ := ctx.Value(ctxkey).(*ctxdata)
+	for _, n := range h.nodes {
+		cd = &ctxdata{prev: cd, fields: n.fields, group: n.group}
+	}
+	return l.print(ctx, cd, fields, 1, r.Message)
+}
+
+// WithAttrs returns Handler which adds attrs to every record.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+
+	var fields []Field
+	for _, a := range attrs {
+		fields = appendAttr(fields, a)
+	}
+	return h.with(ctxdata{fields: fields})
+}
+
+// WithGroup returns Handler which nests attributes added later under name, see WithGroup.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	return h.with(ctxdata{group: name})
+}
+
+func (h *Handler) with(n ctxdata) *Handler {
+	nodes := make([]ctxdata, len(h.nodes), len(h.nodes)+1)
+	copy(nodes, h.nodes)
+	return &Handler{l: h.l, nodes: append(nodes, n)}
+}
+
+// slogLevel maps slog level to the nearest level not above it.
+func slogLevel(level slog.Level) string {
+	switch {
+	case level < slog.LevelInfo:
+		return LevelDebug
+	case level < slog.LevelWarn:
+		return LevelInfo
+	case level < slog.LevelError:
+		return LevelWarn
+	default:
+		return LevelError
+	}
+}
+
+// appendAttr appends a converted to Field to fs.
+// Groups with empty key are inlined, errors are printed as strings,
+// and error with key "error" is handled as Error field.
+func appendAttr(fs []Field, a slog.Attr) []Field {
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindString:
+		return append(fs, Str(a.Key, v.String()))
+	case slog.KindInt64:
+		return append(fs, Int64(a.Key, v.Int64()))
+	case slog.KindUint64:
+		return append(fs, Value(a.Key, v.Uint64()))
+	case slog.KindFloat64:
+		return append(fs, Float64(a.Key, v.Float64()))
+	case slog.KindBool:
+		return append(fs, Bool(a.Key, v.Bool()))
+	case slog.KindDuration:
+		return append(fs, Dur(a.Key, v.Duration()))
+	case slog.KindTime:
+		return append(fs, Value(a.Key, v.Time()))
+	case slog.KindGroup:
+		var group []Field
+		for _, a := range v.Group() {
+			group = appendAttr(group, a)
+		}
+		if a.Key == "" {
+			return append(fs, group...)
+		}
+		if len(group) == 0 {
+			return fs
+		}
+		return append(fs, Field{key: a.Key, kind: kindObject, val: group})
+	default:
+		if err, ok := v.Any().(error); ok {
+			if a.Key == "error" {
+				return append(fs, Error(err))
+			}
+			return append(fs, Str(a.Key, err.Error()))
+		}
+		return append(fs, Value(a.Key, v.Any()))
+	}
+}
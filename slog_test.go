@@ -0,0 +1,61 @@
+package ctxlog_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+	"testing/slogtest"
+	"time"
+
+	"github.com/kaey/ctxlog"
+)
+
+func TestSlogHandler(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.MinLevel(ctxlog.LevelInfo))
+	ctx := ctxlog.With(context.Background(), ctxlog.Str("request_id", "1"))
+
+	logger := slog.New(log.SlogHandler()).With("component", "db").WithGroup("query")
+	logger.DebugContext(ctx, "dropped")
+
+	r := slog.NewRecord(time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC), slog.LevelWarn, "slow query", 0)
+	r.Add("table", "users", slog.Duration("took", time.Second), "error", errors.New("timeout"))
+	logger.Handler().Handle(ctx, r)
+
+	r = slog.NewRecord(time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC), slog.LevelError, "failed", 0)
+	r.AddAttrs(slog.Group("", slog.Int("inlined", 1)), slog.Group("empty"))
+	log.SlogHandler().Handle(ctx, r)
+
+	expected := `{"msg":"slow query","level":"warn","request_id":"1","component":"db","error":"timeout","query":{"table":"users","took":"1s"},"time":"2001-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"failed","level":"error","request_id":"1","inlined":1,"time":"2001-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestSlogtest(t *testing.T) {
+	buf := new(bytes.Buffer)
+	h := ctxlog.New(buf).SlogHandler()
+
+	results := func() []map[string]any {
+		var ms []map[string]any
+		for _, line := range bytes.Split(buf.Bytes(), []byte{'\n'}) {
+			if len(line) == 0 {
+				continue
+			}
+			var m map[string]any
+			if err := json.Unmarshal(line, &m); err != nil {
+				t.Fatal(err)
+			}
+			ms = append(ms, m)
+		}
+		return ms
+	}
+	if err := slogtest.TestHandler(h, results); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,150 @@
+package ctxlog
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Stacker can be implemented by errors to include stack trace info in logs.
+// Use runtime.Callers to get pc slice.
+type Stacker interface {
+	Stack() (pc []uintptr)
+}
+
+// maxCallerStack is the maximum number of frames captured by StackOnError.
+const maxCallerStack = 64
+
+// pkgPrefix is the prefix of names of functions of this package, like "github.com/kaey/ctxlog.".
+var pkgPrefix = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	name := runtime.FuncForPC(pc).Name() // github.com/kaey/ctxlog.init.func1
+	pkg := strings.LastIndex(name, "/") + 1
+	return name[:pkg+strings.Index(name[pkg:], ".")+1]
+}()
+
+// maxStackCache is the maximum number of stacks kept by stackCache, the cache is cleared when full.
+const maxStackCache = 1024
+
+// stackKey identifies pc slice returned by Stacker, errors logged repeatedly return the same slice.
+type stackKey struct {
+	pc *uintptr
+	n  int
+}
+
+// stackCache keeps formatted stacks of errors, so each unique stack is symbolized once.
+type stackCache struct {
+	mu sync.Mutex
+	m  map[stackKey]any
+}
+
+// cachedStack returns l.stack(pc), formatted stacks are cached by pc slice identity.
+// Stacker implementations must not modify the returned slice after it has been logged.
+func (l *Log) cachedStack(pc []uintptr) any {
+	c := l.stacks
+	if c == nil || len(pc) == 0 {
+		return l.stack(pc)
+	}
+
+	k := stackKey{pc: &pc[0], n: len(pc)}
+	c.mu.Lock()
+	st, ok := c.m[k]
+	c.mu.Unlock()
+	if ok {
+		return st
+	}
+
+	st = l.stack(pc)
+	c.mu.Lock()
+	if len(c.m) >= maxStackCache {
+		clear(c.m)
+	}
+	c.m[k] = st
+	c.mu.Unlock()
+	return st
+}
+
+// stack returns frames of pc formatted as set by MaxStackDepth and StructuredStack.
+// Leading frames of the runtime and of this package are skipped.
+func (l *Log) stack(pc []uintptr) any {
+	var (
+		st     []string
+		frames []map[string]any
+		top    = true
+	)
+	it := runtime.CallersFrames(pc)
+	for n := 0; l.maxStackDepth <= 0 || n < l.maxStackDepth; {
+		frame, more := it.Next()
+		if top && (strings.HasPrefix(frame.Function, "runtime.") || strings.HasPrefix(frame.Function, pkgPrefix)) && more {
+			continue
+		}
+		top = false
+		n++
+
+		if l.structuredStack {
+			frames = append(frames, map[string]any{"file": frame.File, "line": frame.Line, "func": frame.Function})
+		} else {
+			st = append(st, fmt.Sprintf("%s:%d[%s]", frame.File, frame.Line, frame.Function))
+		}
+		if !more {
+			break
+		}
+	}
+
+	if l.structuredStack {
+		return frames
+	}
+	return st
+}
+
+// findStacker returns the deepest error in the tree of err implementing Stacker.
+// The deepest stack is the closest to the origin of the error.
+func findStacker(err error) (Stacker, bool) {
+	var (
+		found Stacker
+		depth = -1
+		seen  int
+	)
+
+	var walk func(err error, d int)
+	walk = func(err error, d int) {
+		if err == nil || seen >= maxErrorChain {
+			return
+		}
+		seen++
+
+		if st, ok := err.(Stacker); ok && len(st.Stack()) > 0 && d > depth {
+			found, depth = st, d
+		}
+
+		switch x := err.(type) {
+		case interface{ Unwrap() error }:
+			walk(x.Unwrap(), d+1)
+		case interface{ Unwrap() []error }:
+			for _, err := range x.Unwrap() {
+				walk(err, d+1)
+			}
+		}
+	}
+	walk(err, 0)
+
+	return found, found != nil
+}
+
+// goid returns id of the current goroutine parsed from the header of its stack, like "goroutine 42 [running]:".
+func goid() (int64, bool) {
+	var arr [64]byte
+	b := arr[:runtime.Stack(arr[:], false)]
+	b, ok := bytes.CutPrefix(b, []byte("goroutine "))
+	if !ok {
+		return 0, false
+	}
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	return id, err == nil
+}
@@ -0,0 +1,51 @@
+//go:build !windows && !plan9
+
+package ctxlog
+
+import (
+	"bytes"
+	"io"
+	"log/syslog"
+)
+
+// SyslogWriter returns writer which sends every line to w with severity mapped from level of the message:
+// debug to LOG_DEBUG, info to LOG_INFO, warn to LOG_WARNING, error to LOG_ERR and fatal to LOG_CRIT.
+// Messages without level or with unknown level are sent as LOG_INFO.
+// Use it as a writer of New, lines are encoded as usual and sent as the syslog message.
+func SyslogWriter(w *syslog.Writer) io.WriteCloser {
+	return &syslogWriter{w: w}
+}
+
+type syslogWriter struct {
+	w *syslog.Writer
+}
+
+func (w *syslogWriter) Write(p []byte) (int, error) {
+	return w.WriteLevel(LevelInfo, p)
+}
+
+// WriteLevel implements levelWriter.
+func (w *syslogWriter) WriteLevel(level string, p []byte) (int, error) {
+	m := string(bytes.TrimSuffix(p, []byte{'\n'}))
+	var err error
+	switch level {
+	case LevelDebug:
+		err = w.w.Debug(m)
+	case LevelWarn:
+		err = w.w.Warning(m)
+	case LevelError:
+		err = w.w.Err(m)
+	case LevelFatal:
+		err = w.w.Crit(m)
+	default:
+		err = w.w.Info(m)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *syslogWriter) Close() error {
+	return w.w.Close()
+}
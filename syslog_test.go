@@ -0,0 +1,47 @@
+//go:build !windows && !plan9
+
+package ctxlog_test
+
+import (
+	"context"
+	"log/syslog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kaey/ctxlog"
+)
+
+func TestSyslogWriter(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sw, err := syslog.Dial("udp", conn.LocalAddr().String(), syslog.LOG_USER, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := ctxlog.SyslogWriter(sw)
+	defer w.Close()
+	log := ctxlog.New(w, ctxlog.Logfmt(), ctxlog.NoTime())
+	ctx := context.Background()
+
+	log.Print(ctx, "foo", ctxlog.Level(ctxlog.LevelError))
+	log.Print(ctx, "bar")
+
+	p := make([]byte, 1024)
+	for _, expected := range []string{"<11>", "<14>"} {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := conn.ReadFrom(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := string(p[:n])
+		if !strings.HasPrefix(got, expected) {
+			t.Errorf("expected: %v, got: %v", expected, got)
+		}
+	}
+}
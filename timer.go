@@ -0,0 +1,31 @@
+package ctxlog
+
+import (
+	"context"
+	"time"
+)
+
+type timerkeytype struct{}
+
+var timerkey = timerkeytype{}
+
+// StartTimer returns new context which remembers current time, see Elapsed and WithElapsed.
+func StartTimer(ctx context.Context) context.Context {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return context.WithValue(ctx, timerkey, time.Now())
+}
+
+// Elapsed returns "elapsed_ms" field with number of milliseconds since StartTimer was called for ctx.
+// If timer wasn't started, field with empty key is returned, which is not printed.
+func Elapsed(ctx context.Context) Field {
+	if ctx == nil {
+		return Field{}
+	}
+	start, ok := ctx.Value(timerkey).(time.Time)
+	if !ok {
+		return Field{}
+	}
+	return Int64("elapsed_ms", time.Since(start).Milliseconds())
+}
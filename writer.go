@@ -0,0 +1,96 @@
+package ctxlog
+
+import (
+	"bytes"
+	"context"
+	"io"
+	stdlog "log"
+	"sync"
+)
+
+// Writer is LevelWriter at info level.
+func (l *Log) Writer(ctx context.Context) io.WriteCloser {
+	return l.LevelWriter(ctx, LevelInfo)
+}
+
+// LevelWriter returns io.WriteCloser which calls l.Print at level for every line written to it.
+// Incomplete line is buffered until the rest of the line is written or Close is called,
+// or until it grows above 64KB, then it is printed as it is.
+// Leading and trailing spaces are trimmed, blank lines are not printed.
+func (l *Log) LevelWriter(ctx context.Context, level string) io.WriteCloser {
+	return &writer{
+		l:      l,
+		ctx:    ctx,
+		fields: []Field{Level(level)},
+	}
+}
+
+// StdLogger returns *log.Logger which prints every message with l at level.
+// Use it with libraries which accept *log.Logger.
+func (l *Log) StdLogger(ctx context.Context, level string) *stdlog.Logger {
+	return stdlog.New(&writer{l: l, ctx: ctx, fields: []Field{Level(level)}, skip: stdLoggerSkip}, "", 0)
+}
+
+// maxWriterLine is the size of incomplete line buffered by writer, longer lines are printed in parts.
+const maxWriterLine = 64 << 10
+
+// stdLoggerSkip is the number of frames of *log.Logger between its caller and writer.Write:
+// Printf or another printing method and Logger.output.
+const stdLoggerSkip = 2
+
+type writer struct {
+	l      *Log
+	global bool // print with Global logger instead of l
+	ctx    context.Context
+	fields []Field
+	skip   int // frames between the caller and Write, see stdLoggerSkip
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *writer) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	start := 0
+	for {
+		i := bytes.IndexByte(w.buf[start:], '\n')
+		if i < 0 {
+			break
+		}
+		w.print(w.buf[start : start+i])
+		start += i + 1
+	}
+	if len(w.buf)-start > maxWriterLine {
+		w.print(w.buf[start:])
+		start = len(w.buf)
+	}
+	w.buf = w.buf[:copy(w.buf, w.buf[start:])]
+	return len(p), nil
+}
+
+// Close prints buffered incomplete line, if any.
+func (w *writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.print(w.buf)
+		w.buf = w.buf[:0]
+	}
+	return nil
+}
+
+func (w *writer) print(line []byte) {
+	l := w.l
+	if w.global {
+		l = log.Load()
+	}
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+	l.output(w.ctx, 3+w.skip, string(line), w.fields)
+}
@@ -0,0 +1,92 @@
+package ctxlog_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/kaey/ctxlog"
+)
+
+func TestWriterLines(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	w := log.Writer(context.Background())
+
+	w.Write([]byte("line1\nli"))
+	w.Write([]byte("ne2\n\n  \t\nline3\nparti"))
+	w.Write([]byte("al"))
+	w.Close()
+
+	expected := `{"msg":"line1","level":"info","time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"line2","level":"info","time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"line3","level":"info","time":"2000-01-01T00:00:00Z"}` + "\n" +
+		`{"msg":"partial","level":"info","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestWriterLongLine(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Logfmt(), ctxlog.NoTime())
+	w := log.Writer(context.Background())
+
+	// Line without newline is printed once it grows above the limit.
+	part := bytes.Repeat([]byte("x"), 40<<10)
+	w.Write(part)
+	if buf.Len() != 0 {
+		t.Errorf("expected: %v, got: %v", 0, buf.Len())
+	}
+	w.Write(part)
+	w.Write([]byte("y\n"))
+
+	expected := "msg=" + string(part) + string(part) + " level=info\nmsg=y level=info\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v bytes, got: %v bytes", len(expected), len(got))
+	}
+}
+
+func TestStdLogger(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := ctxlog.With(context.Background(), ctxlog.Str("lib", "foo"))
+
+	log.StdLogger(ctx, ctxlog.LevelWarn).Printf("retrying %d", 1)
+
+	// Caller is the code which called *log.Logger.
+	log = ctxlog.New(buf, ctxlog.WithCaller(), ctxlog.NoTime())
+	ctxlog.Global(log)
+	defer ctxlog.Global(nil)
+	_, _, line, _ := runtime.Caller(0)
+	log.StdLogger(ctx, ctxlog.LevelWarn).Println("foo")
+	ctxlog.StdLogger(ctx, ctxlog.LevelWarn).Print("bar")
+
+	expected := `{"msg":"retrying 1","level":"warn","lib":"foo","time":"2000-01-01T00:00:00Z"}` + "\n" +
+		fmt.Sprintf(`{"msg":"foo","level":"warn","lib":"foo","caller":"writer_test.go:%d"}`+"\n", line+1) +
+		fmt.Sprintf(`{"msg":"bar","level":"warn","lib":"foo","caller":"writer_test.go:%d"}`+"\n", line+2)
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}
+
+func TestLevelWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	log := ctxlog.New(buf, ctxlog.MinLevel(ctxlog.LevelInfo), ctxlog.Time(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)))
+	ctx := context.Background()
+
+	log.LevelWriter(ctx, ctxlog.LevelDebug).Write([]byte("dropped\n"))
+	log.LevelWriter(ctx, ctxlog.LevelWarn).Write([]byte("stderr\n"))
+
+	expected := `{"msg":"stderr","level":"warn","time":"2000-01-01T00:00:00Z"}` + "\n"
+	got := buf.String()
+	if expected != got {
+		t.Errorf("expected: %v, got: %v", expected, got)
+	}
+}